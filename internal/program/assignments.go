@@ -0,0 +1,70 @@
+package program
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// fetchAssignments retrieves the workspace-to-output assignments from the
+// loaded i3/sway configuration via `get_config`. Files pulled in with
+// `include` are not followed.
+func fetchAssignments(ctx context.Context, query ipcQueryFunc) (map[string]string, error) {
+	out, err := query(ctx, "get_config")
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Config string `json:"config"`
+	}
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return nil, fmt.Errorf("unmarshal config JSON: %w", err)
+	}
+	return parseAssignments(reply.Config), nil
+}
+
+// parseAssignments extracts `workspace <name> output <outputs...>` lines
+// from an i3/sway config, mapping each workspace name to the first listed
+// output, which is the one the compositor prefers. `set $var value`
+// variables are expanded.
+func parseAssignments(config string) map[string]string {
+	vars := make(map[string]string)
+	assigned := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "set" && len(fields) >= 3 && strings.HasPrefix(fields[1], "$") {
+			vars[fields[1]] = strings.Join(fields[2:], " ")
+			continue
+		}
+		if fields[0] != "workspace" {
+			continue
+		}
+
+		for i, f := range fields {
+			if v, ok := vars[f]; ok {
+				fields[i] = v
+			}
+		}
+		// the workspace name may contain spaces, so split on the keyword
+		idx := -1
+		for i := 1; i < len(fields)-1; i++ {
+			if fields[i] == "output" {
+				idx = i
+				break
+			}
+		}
+		if idx < 2 {
+			continue
+		}
+		name := strings.Trim(strings.Join(fields[1:idx], " "), `"'`)
+		assigned[name] = strings.Trim(fields[idx+1], `"'`)
+	}
+	return assigned
+}
@@ -0,0 +1,254 @@
+package program
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Backend is a compositor the workspace state is read from. Rendering and
+// the subscription loop only go through this interface.
+//
+// To add a compositor, implement Backend in its own file and add its
+// detector to the backends registry below. Optional features such as the
+// scratchpad count are enabled by also implementing treeBackend or
+// assignmentBackend. Workspaces must be adapted to the
+// i3/sway Workspace shape, and events labelled with the i3/sway subscription
+// they correspond to ("workspace", "output" or "window") so the watcher can
+// decide whether to re-render.
+type Backend interface {
+	// Name identifies the compositor, e.g. "sway".
+	Name() string
+	// Workspaces returns the current workspaces.
+	Workspaces(ctx context.Context) ([]Workspace, error)
+	// Subscribe streams events until ctx is cancelled or the connection is
+	// lost, at which point the channel is closed.
+	Subscribe(ctx context.Context) (<-chan Event, error)
+	// Command returns the shell command performing action ("switch",
+	// "move", "next", "prev" or "scratchpad") on target, which is a
+	// workspace for switch/move and an output for next/prev. It returns ""
+	// if the compositor does not support the action, and the bare command
+	// prefix for an empty action.
+	Command(action, target string) string
+}
+
+// treeBackend is implemented by backends that expose the i3/sway layout tree.
+type treeBackend interface {
+	Tree(ctx context.Context) (treeNode, error)
+}
+
+// assignmentBackend is implemented by backends that can report which output
+// each workspace is assigned to.
+type assignmentBackend interface {
+	Assignments(ctx context.Context) (map[string]string, error)
+}
+
+// outputBackend is implemented by backends that can list the active outputs.
+type outputBackend interface {
+	Outputs(ctx context.Context) ([]wmOutput, error)
+}
+
+// wmOutput is an active output as reported by the compositor.
+type wmOutput struct {
+	Name string
+	// Description identifies the display by make, model and serial, as
+	// accepted in place of the name in sway and Hyprland configs.
+	Description string
+	Focused     bool
+}
+
+// focusedOutput returns the focused output, falling back to the first one
+// with a warning, or false if there are none.
+func focusedOutput(outputs []wmOutput) (string, bool) {
+	for _, o := range outputs {
+		if o.Focused {
+			return o.Name, true
+		}
+	}
+	if len(outputs) == 0 {
+		return "", false
+	}
+	slog.Warn("no output is focused, using the first active one", "output", outputs[0].Name)
+	return outputs[0].Name, true
+}
+
+// modeBackend is implemented by backends with binding modes, whose changes
+// are labelled "mode" on the event stream with the mode name as the change.
+type modeBackend interface {
+	Mode(ctx context.Context) (string, error)
+}
+
+// eventBackend is implemented by backends whose workspace events carry
+// enough state to update the workspace list without fetching it again.
+type eventBackend interface {
+	// Apply returns wss updated for the workspace event ev, or false if
+	// the list has to be fetched again.
+	Apply(wss []Workspace, ev Event) ([]Workspace, bool)
+}
+
+// The compositor CLIs are reached through these variables so tests can
+// substitute canned replies and event streams for a running compositor.
+var (
+	// lookPath finds a compositor CLI on PATH.
+	lookPath = exec.LookPath
+	// commandOutput runs a command to completion and returns its stdout.
+	commandOutput = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		out, err := exec.CommandContext(ctx, name, args...).Output()
+		if err != nil && ctx.Err() != nil {
+			// report why the command was killed, not just the signal
+			err = fmt.Errorf("%w (%v)", ctx.Err(), err)
+		}
+		return out, err
+	}
+	// commandStream starts a long-running command and returns its stdout
+	// along with a function that waits for it to exit. The command runs in
+	// its own process group, which is killed when ctx is cancelled so that
+	// helpers started by a shell do not outlive it.
+	commandStream = func(ctx context.Context, name string, args ...string) (io.Reader, func() error, error) {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+		// do not block in Wait on pipes inherited by stray descendants
+		cmd.WaitDelay = time.Second
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return stdout, cmd.Wait, nil
+	}
+)
+
+// detector returns a Backend if its compositor is running.
+type detector func(ctx context.Context, cfg config) (Backend, bool)
+
+// backends lists the detectors in order of preference. Compositors that
+// advertise themselves through the environment come first, since their CLIs
+// may be installed alongside others.
+var backends = []detector{
+	detectHyprland,
+	detectNiri,
+	detectRiver,
+	detectSway,
+	detectI3,
+}
+
+// detectBackend returns the first running compositor from the registry, or
+// errNoCompositor if none is. Workspaces fed through --workspaces-from or
+// --workspaces-file take the place of a compositor.
+func detectBackend(ctx context.Context, cfg config) (Backend, error) {
+	if be := newFeedBackend(cfg); be != nil {
+		return be, nil
+	}
+	detectors := backends
+	if cfg.Socket != "" {
+		// only i3 and sway are reached through a socket path
+		detectors = []detector{detectSway, detectI3}
+	}
+	for _, detect := range detectors {
+		ctx, cancel := context.WithTimeout(ctx, cfg.DetectTimeout)
+		be, ok := detect(ctx, cfg)
+		cancel()
+		if ok {
+			return be, nil
+		}
+	}
+	if cfg.Socket != "" {
+		return nil, fmt.Errorf("%w: no i3 or sway answers on %s", errNoCompositor, cfg.Socket)
+	}
+	return nil, fmt.Errorf("%w (looked for %s)", errNoCompositor, strings.Join(compositorCLIs, ", "))
+}
+
+// compositorCLIs lists the compositor CLIs the backends run.
+var compositorCLIs = []string{"hyprctl", "niri", "riverctl", "swaymsg", "i3-msg"}
+
+// streamEvents parses lines from r into events on the returned channel,
+// which is closed once r is exhausted or ctx is cancelled. Malformed lines
+// and events without a change are skipped. done is called after reading
+// stops, before the channel is closed.
+func streamEvents(ctx context.Context, r io.Reader, parse func([]byte) (Event, error), done func()) <-chan Event {
+	evCh := make(chan Event)
+	go func() {
+		defer close(evCh)
+		defer done()
+
+		scanner := bufio.NewScanner(r)
+		// window events carry the whole container and can outgrow the default buffer
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			ev, err := parse(scanner.Bytes())
+			if err != nil {
+				slog.Warn("skipping malformed event", "err", err)
+				continue
+			}
+			// acknowledgements and ignored events have no change
+			if ev.Change == "" {
+				continue
+			}
+			select {
+			case evCh <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			slog.Error("event stream failed", "err", err)
+		}
+	}()
+	return evCh
+}
+
+// eventBuffer is the number of events bufferEvents holds.
+const eventBuffer = 64
+
+// bufferEvents forwards the events from in, holding up to eventBuffer of
+// them while the receiver is busy, e.g. fetching the tree for a render, so
+// the subscription keeps being read and its pipe never fills. When the
+// buffer is full the oldest event is dropped, since only the latest state
+// matters, and the next one delivered is marked lost. Once ctx is cancelled
+// in is drained before the returned channel is closed.
+func bufferEvents(ctx context.Context, in <-chan Event) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		var queue []Event
+		for in != nil || len(queue) > 0 {
+			var send chan<- Event
+			var next Event
+			if len(queue) > 0 {
+				send, next = out, queue[0]
+			}
+			select {
+			case <-ctx.Done():
+				if in != nil {
+					for range in {
+					}
+				}
+				return
+			case ev, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if len(queue) == eventBuffer {
+					queue = queue[1:]
+					queue[0].lost = true
+				}
+				queue = append(queue, ev)
+			case send <- next:
+				queue = queue[1:]
+			}
+		}
+	}()
+	return out
+}
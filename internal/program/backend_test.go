@@ -0,0 +1,211 @@
+package program
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCompositor stands in for a running sway, answering swaymsg through
+// the command seams with canned replies and a scripted subscribe stream.
+type fakeCompositor struct {
+	mu sync.Mutex
+	// workspaces is the get_workspaces reply, after the queued ones.
+	workspaces string
+	queued     []string
+	// failures is the number of get_workspaces calls still to fail.
+	failures int
+	// fetches counts the get_workspaces calls.
+	fetches int
+	// events are written to the subscribe stream after the
+	// acknowledgement, and a send on ends closes the stream.
+	events chan string
+	ends   chan struct{}
+	// subscribes counts the subscriptions.
+	subscribes int
+}
+
+// newFakeCompositor installs a fake sway replying workspaces to
+// get_workspaces for the duration of the test.
+func newFakeCompositor(t *testing.T, workspaces string) *fakeCompositor {
+	t.Helper()
+	f := &fakeCompositor{workspaces: workspaces, events: make(chan string), ends: make(chan struct{})}
+	// no other compositor may be detected before the fake
+	for _, env := range []string{"SWAYSOCK", "I3SOCK", "HYPRLAND_INSTANCE_SIGNATURE", "NIRI_SOCKET", "XDG_CURRENT_DESKTOP"} {
+		t.Setenv(env, "")
+	}
+	origLookPath, origOutput, origStream := lookPath, commandOutput, commandStream
+	t.Cleanup(func() {
+		lookPath, commandOutput, commandStream = origLookPath, origOutput, origStream
+	})
+	lookPath = func(name string) (string, error) {
+		if name != "swaymsg" {
+			return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+		}
+		return name, nil
+	}
+	commandOutput = f.output
+	commandStream = f.stream
+	return f
+}
+
+// setWorkspaces replaces the get_workspaces reply.
+func (f *fakeCompositor) setWorkspaces(reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.workspaces = reply
+}
+
+// queue makes the next get_workspaces calls reply with replies, in order.
+func (f *fakeCompositor) queue(replies ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queued = append(f.queued, replies...)
+}
+
+// failNext makes the next n get_workspaces calls fail.
+func (f *fakeCompositor) failNext(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakeCompositor) output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name != "swaymsg" || len(args) != 2 || args[0] != "-t" {
+		return nil, fmt.Errorf("unexpected command %s %s", name, strings.Join(args, " "))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[1] {
+	case "get_version":
+		return []byte(`{"variant":"sway"}`), nil
+	case "get_outputs":
+		return []byte(`[{"name":"DP-1","active":true,"focused":true},{"name":"HDMI-A-1","active":true}]`), nil
+	case "get_tree":
+		return []byte(`{"type":"root","nodes":[]}`), nil
+	case "get_binding_state":
+		return []byte(`{"name":"default"}`), nil
+	case "get_workspaces":
+		f.fetches++
+		if f.failures > 0 {
+			f.failures--
+			return nil, errors.New("exit status 2")
+		}
+		if len(f.queued) > 0 {
+			reply := f.queued[0]
+			f.queued = f.queued[1:]
+			return []byte(reply), nil
+		}
+		return []byte(f.workspaces), nil
+	}
+	return nil, fmt.Errorf("unexpected message type %s", args[1])
+}
+
+func (f *fakeCompositor) stream(ctx context.Context, name string, args ...string) (io.Reader, func() error, error) {
+	if name != "swaymsg" || len(args) < 2 || args[1] != "subscribe" {
+		return nil, nil, fmt.Errorf("unexpected command %s %s", name, strings.Join(args, " "))
+	}
+	f.mu.Lock()
+	f.subscribes++
+	f.mu.Unlock()
+	r, w := io.Pipe()
+	// like killing the subscribe process, this unblocks the writer
+	context.AfterFunc(ctx, func() { w.Close() })
+	// exited is closed once the fake subscribe process is done
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		fmt.Fprintln(w, `{"success":true}`)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-f.ends:
+				w.Close()
+				return
+			case ev := <-f.events:
+				if _, err := fmt.Fprintln(w, ev); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	wait := func() error {
+		<-exited
+		return nil
+	}
+	return r, wait, nil
+}
+
+// testConfig returns the configuration parsed from args, ignoring any
+// config file and $EWW_MONITOR.
+func testConfig(t *testing.T, args ...string) config {
+	t.Helper()
+	t.Setenv(monitorEnv, "")
+	cfg, _, err := parseFlags(append([]string{"watch", "-config", ""}, args...), flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestRenderFetchedWorkspaces(t *testing.T) {
+	tests := []struct {
+		name string
+		// reply is the get_workspaces reply.
+		reply string
+		// classes are the classes of buttons 1 to 3, in order.
+		classes []string
+	}{
+		{
+			name:    "unoccupied",
+			reply:   `[]`,
+			classes: []string{"unoccupied", "unoccupied", "unoccupied"},
+		},
+		{
+			name:    "focused",
+			reply:   `[{"num":2,"name":"2","focused":true,"visible":true,"output":"DP-1"}]`,
+			classes: []string{"unoccupied", "focused", "unoccupied"},
+		},
+		{
+			name:    "urgent",
+			reply:   `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"},{"num":3,"name":"3","urgent":true,"output":"DP-1"}]`,
+			classes: []string{"focused", "unoccupied", "urgent"},
+		},
+		{
+			name:    "occupied",
+			reply:   `[{"num":1,"name":"1","output":"DP-1"},{"num":2,"name":"2","focused":true,"visible":true,"output":"DP-1"}]`,
+			classes: []string{"occupied", "focused", "unoccupied"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeCompositor(t, tt.reply)
+			cfg := testConfig(t, "-end-workspace", "3")
+			be, err := detectBackend(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap, err := fetchSnapshot(context.Background(), be, cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf strings.Builder
+			if _, err := render(&buf, snap, be, "DP-1", cfg); err != nil {
+				t.Fatal(err)
+			}
+			for i, class := range tt.classes {
+				want := fmt.Sprintf(`(button :onclick "swaymsg 'workspace %d'" :visible true :class "%s" "%d")`, i+1, class, i+1)
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("widget %s\nlacks %s", buf.String(), want)
+				}
+			}
+		})
+	}
+}
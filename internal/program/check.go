@@ -0,0 +1,73 @@
+package program
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// runCheck verifies the setup the way a real run would use it, without
+// subscribing or printing a widget, and reports each step to report. It
+// returns the first failure.
+func runCheck(ctx context.Context, cfg config, report io.Writer) error {
+	var first error
+	step := func(name, detail string, err error) {
+		if err != nil {
+			fmt.Fprintf(report, "FAIL %s: %v\n", name, err)
+			if first == nil {
+				first = err
+			}
+			return
+		}
+		fmt.Fprintf(report, "OK   %s: %s\n", name, detail)
+	}
+
+	be, err := detectBackend(ctx, cfg)
+	w := &watcher{cfg: cfg, be: be}
+	var snap snapshot
+	if err == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, cfg.FetchTimeout)
+		snap, err = fetchSnapshot(fetchCtx, be, cfg, nil)
+		cancel()
+		err = explainTimeout(err, "compositor did not respond", cfg.FetchTimeout, "fetch-timeout")
+	}
+	if be == nil {
+		step("compositor", "", err)
+	} else {
+		step("compositor", fmt.Sprintf("%s, %d workspaces", be.Name(), len(snap.Workspaces)), err)
+	}
+
+	var fileErr error
+	if cfg.usesMonitorsFile() {
+		// unlike a real run, do not wait for the file to appear
+		fileCtx, cancel := context.WithTimeout(ctx, cfg.FilePollInterval)
+		var monitors []MonitorInfo
+		monitors, fileErr = readMonitors(fileCtx, cfg.MonitorsFile, cfg.FilePollInterval)
+		cancel()
+		step("monitors file", fmt.Sprintf("%s, %d monitors", cfg.MonitorsFile, len(monitors)), fileErr)
+	}
+
+	switch {
+	case fileErr != nil:
+		fmt.Fprintln(report, "SKIP output: needs the monitors file")
+	case be == nil && cfg.OutputsFromWM:
+		fmt.Fprintln(report, "SKIP output: needs the compositor")
+	default:
+		err := w.refresh(ctx)
+		detail := w.output
+		if cfg.AllMonitors {
+			detail = fmt.Sprintf("%d monitors", len(w.monitors))
+		}
+		step("output", detail, err)
+	}
+
+	if be == nil {
+		fmt.Fprintln(report, "SKIP widget: needs the compositor")
+		return first
+	}
+	// the button template is compiled while parsing the flags; this
+	// exercises it and the box format against the fetched state
+	_, err = buildWidget(snap, be, cfg.Monitor, w.output, cfg)
+	step("widget", cfg.Format, err)
+	return first
+}
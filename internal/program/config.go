@@ -0,0 +1,540 @@
+package program
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/qikiqi/go-eww-workspaces/workspaces"
+)
+
+// config holds the settings resolved from the config file and command line.
+type config struct {
+	Monitor            string
+	MonitorsFile       string
+	MonitorsFileSet    bool
+	StartWS            int
+	DisplayOffset      int
+	EndWS              int
+	BoxClass           string
+	GroupSize          int
+	Separator          string
+	PrefixWidget       string
+	SuffixWidget       string
+	ActiveOutputClass  string
+	Orientation        string
+	Halign             string
+	Valign             string
+	Spacing            int
+	SpaceEvenly        bool
+	UseNames           bool
+	DynamicRange       bool
+	Reverse            bool
+	HideEmpty          bool
+	Persistent         []string
+	OnEvents           []string
+	MaxReconnect       int
+	Debounce           time.Duration
+	Refresh            time.Duration
+	UrgentFlash        time.Duration
+	AllMonitors        bool
+	OutputsFromWM      bool
+	PollInterval       time.Duration
+	FilePollInterval   time.Duration
+	FetchTimeout       time.Duration
+	FetchRetries       int
+	FetchRetryDelay    time.Duration
+	InitialTimeout     time.Duration
+	DetectTimeout      time.Duration
+	Format             string
+	JSONIncludeMeta    bool
+	Sink               string
+	EwwVar             string
+	Fifo               []string
+	UrgentPriority     string
+	ShowScratchpad     bool
+	Tooltips           bool
+	ShowCounts         bool
+	ShowMode           bool
+	ShowFullscreen     bool
+	ClassByNum         bool
+	LeftClick          string
+	OnClickCmd         string
+	OnClickAsync       bool
+	MiddleClick        string
+	RightClick         string
+	ScrollSwitch       bool
+	ScrollWrap         bool
+	AlwaysRender       bool
+	NoInitialRender    bool
+	RespectAssignments bool
+	RiverStatusCmd     string
+	Socket             string
+	Quiet              bool
+	WorkspacesFrom     string
+	WorkspacesFile     string
+	// AppIcons maps app IDs to the icons shown for them.
+	AppIcons map[string]string
+	// StateClasses maps button states to the CSS classes emitted for them.
+	StateClasses map[string]string
+	// OutputAliases maps output names, e.g. from the monitors file, to the
+	// names the compositor reports.
+	OutputAliases map[string]string
+
+	ButtonTemplate *template.Template
+	LabelTemplate  *template.Template
+}
+
+// persistentNums returns the numbers of the persistent workspaces, taken
+// from the leading digits of names like "1:web".
+func (c config) persistentNums() []int {
+	var nums []int
+	for _, p := range c.Persistent {
+		if num, ok := workspaces.NameNum(p); ok {
+			nums = append(nums, num)
+		}
+	}
+	return nums
+}
+
+// canonicalOutput returns the name the compositor uses for output.
+func (c config) canonicalOutput(output string) string {
+	if to, ok := c.OutputAliases[output]; ok {
+		return to
+	}
+	return output
+}
+
+// buttonOptions returns the options computing the buttons from snap.
+func (c config) buttonOptions(snap snapshot) workspaces.Options {
+	return workspaces.Options{
+		StartWS:        c.StartWS,
+		EndWS:          c.EndWS,
+		UseNames:       c.UseNames,
+		DisplayOffset:  c.DisplayOffset,
+		DynamicRange:   c.DynamicRange,
+		Reverse:        c.Reverse,
+		HideEmpty:      c.HideEmpty,
+		Persistent:     c.Persistent,
+		UrgentPriority: c.UrgentPriority,
+		Tooltips:       c.Tooltips,
+		ShowCounts:     c.ShowCounts,
+		Assignments:    snap.Assignments,
+		Windows:        snap.Windows,
+		Fullscreen:     snap.Fullscreen,
+		FocusedApps:    snap.FocusedApps,
+		AppIcons:       c.AppIcons,
+	}
+}
+
+// autoMonitor reports whether the output is detected rather than looked up
+// by monitor name.
+func (c config) autoMonitor() bool {
+	return c.Monitor == "" || c.Monitor == "auto"
+}
+
+// feed reports whether the workspaces are fed through --workspaces-from or
+// --workspaces-file instead of fetched from a compositor.
+func (c config) feed() bool {
+	return c.WorkspacesFrom != "" || c.WorkspacesFile != ""
+}
+
+// usesMonitorsFile reports whether the output(s) are looked up in the
+// monitors file. Outputs reported by the compositor or named by fed
+// workspaces need none.
+func (c config) usesMonitorsFile() bool {
+	return (c.AllMonitors || !c.autoMonitor()) && !c.OutputsFromWM && !c.feed()
+}
+
+// needsTree reports whether rendering uses state from the layout tree.
+func (c config) needsTree() bool {
+	return c.ShowScratchpad || c.Tooltips || c.ShowCounts || c.ShowFullscreen || len(c.AppIcons) > 0
+}
+
+// eventTypes lists the event types accepted by --on-events, as named by the
+// i3/sway subscribe IPC.
+var eventTypes = []string{"workspace", "output", "window", "mode"}
+
+// workspaceChanges lists the changes of i3/sway workspace events, which
+// --on-events accepts as workspace:CHANGE.
+var workspaceChanges = []string{"init", "empty", "focus", "move", "rename", "urgent", "reload", "restored"}
+
+// rendersOn reports whether events of type t with the given change trigger a
+// render.
+func (c config) rendersOn(t, change string) bool {
+	if len(c.OnEvents) > 0 {
+		return slices.Contains(c.OnEvents, t) || slices.Contains(c.OnEvents, t+":"+change)
+	}
+	switch t {
+	case "workspace", "output":
+		return true
+	case "window":
+		// window events only matter for state derived from the layout tree
+		return c.needsTree()
+	case "mode":
+		return c.ShowMode
+	}
+	return false
+}
+
+// subscribedEvents returns the event types to subscribe to where the
+// compositor lets clients choose.
+func (c config) subscribedEvents() []string {
+	var events []string
+	for _, t := range eventTypes {
+		someChanges := slices.ContainsFunc(c.OnEvents, func(e string) bool { return strings.HasPrefix(e, t+":") })
+		if c.rendersOn(t, "") || someChanges {
+			events = append(events, t)
+		}
+	}
+	return events
+}
+
+// validate reports whether the configured options are usable together.
+func (c config) validate() error {
+	if c.Format != "eww" && c.Format != "json" {
+		return fmt.Errorf("format must be eww or json, got %q", c.Format)
+	}
+	if !slices.Contains(sinks, c.Sink) {
+		return fmt.Errorf("sink must be one of %s, got %q", strings.Join(sinks, ", "), c.Sink)
+	}
+	if c.Sink == "eww-update" && c.EwwVar == "" {
+		return errors.New("sink eww-update needs eww-var")
+	}
+	if c.Sink == "fifo" && len(c.Fifo) == 0 {
+		return errors.New("sink fifo needs fifo")
+	}
+	for i, path := range c.Fifo {
+		if slices.Contains(c.Fifo[:i], path) {
+			// the readers would take turns getting the widgets
+			return fmt.Errorf("fifo lists %s twice", path)
+		}
+	}
+	if c.UrgentPriority != "urgent" && c.UrgentPriority != "focused" && c.UrgentPriority != "combined" {
+		return fmt.Errorf("urgent-priority must be urgent, focused or combined, got %q", c.UrgentPriority)
+	}
+	for _, click := range []struct{ name, action string }{
+		{"left-click", c.LeftClick},
+		{"middle-click", c.MiddleClick},
+		{"right-click", c.RightClick},
+	} {
+		if !validClickAction(click.action) {
+			return fmt.Errorf("%s must be switch, move or none, got %q", click.name, click.action)
+		}
+	}
+	if c.Orientation != "h" && c.Orientation != "v" {
+		return fmt.Errorf("orientation must be h or v, got %q", c.Orientation)
+	}
+	if !c.UseNames && !c.DynamicRange {
+		for _, num := range c.persistentNums() {
+			if num < c.StartWS || num > c.EndWS {
+				return fmt.Errorf("persistent workspace %d is outside the range %d..%d", num, c.StartWS, c.EndWS)
+			}
+		}
+	}
+	if c.UseNames && c.DynamicRange {
+		return errors.New("use-names and dynamic-range are mutually exclusive")
+	}
+	if c.Socket != "" {
+		info, err := os.Stat(c.Socket)
+		if err != nil {
+			return fmt.Errorf("socket: %w", err)
+		}
+		if info.Mode().Type() != fs.ModeSocket {
+			return fmt.Errorf("socket: %s is not a unix socket", c.Socket)
+		}
+	}
+	for _, t := range c.OnEvents {
+		if t, change, ok := strings.Cut(t, ":"); ok {
+			if t != "workspace" || !slices.Contains(workspaceChanges, change) {
+				return fmt.Errorf("on-events: unknown change %s:%s, want workspace: and one of %s", t, change, strings.Join(workspaceChanges, ", "))
+			}
+			continue
+		}
+		if !slices.Contains(eventTypes, t) {
+			return fmt.Errorf("on-events: unknown event type %q, want %s", t, strings.Join(eventTypes, ", "))
+		}
+	}
+	if c.WorkspacesFrom != "" && c.WorkspacesFrom != "-" {
+		return fmt.Errorf("workspaces-from must be - for stdin, got %q", c.WorkspacesFrom)
+	}
+	if c.WorkspacesFrom != "" && c.WorkspacesFile != "" {
+		return errors.New("workspaces-from and workspaces-file are mutually exclusive")
+	}
+	if c.AllMonitors && c.Monitor != "" {
+		return errors.New("all-monitors and monitor are mutually exclusive")
+	}
+	if c.MaxReconnect < 0 {
+		return fmt.Errorf("max-reconnect must be non-negative, got %d", c.MaxReconnect)
+	}
+	if c.FetchRetries < 0 {
+		return fmt.Errorf("fetch-retries must be non-negative, got %d", c.FetchRetries)
+	}
+	if c.FetchRetryDelay < 0 {
+		return fmt.Errorf("fetch-retry-delay must be non-negative, got %s", c.FetchRetryDelay)
+	}
+	if c.Debounce < 0 {
+		return fmt.Errorf("debounce must be non-negative, got %s", c.Debounce)
+	}
+	if c.Refresh < 0 {
+		return fmt.Errorf("refresh must be non-negative, got %s", c.Refresh)
+	}
+	if c.UrgentFlash < 0 {
+		return fmt.Errorf("urgent-flash must be non-negative, got %s", c.UrgentFlash)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"poll-interval", c.PollInterval},
+		{"file-poll-interval", c.FilePollInterval},
+		{"fetch-timeout", c.FetchTimeout},
+		{"initial-timeout", c.InitialTimeout},
+		{"detect-timeout", c.DetectTimeout},
+	} {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+	if c.Spacing < 0 {
+		return fmt.Errorf("spacing must be non-negative, got %d", c.Spacing)
+	}
+	if c.GroupSize < 0 {
+		return fmt.Errorf("group-size must be non-negative, got %d", c.GroupSize)
+	}
+	if c.Separator != "" && c.GroupSize == 0 {
+		return errors.New("separator needs group-size")
+	}
+	for _, widget := range []struct{ name, value string }{
+		{"separator", c.Separator},
+		{"prefix-widget", c.PrefixWidget},
+		{"suffix-widget", c.SuffixWidget},
+	} {
+		if !balancedParens(widget.value) {
+			return fmt.Errorf("%s has unbalanced parentheses: %s", widget.name, widget.value)
+		}
+	}
+	if c.StartWS < 0 || c.EndWS < 0 {
+		return fmt.Errorf("workspace range must be non-negative, got %d..%d", c.StartWS, c.EndWS)
+	}
+	for state := range c.StateClasses {
+		if !slices.Contains(workspaces.States, state) && state != "fullscreen" {
+			return fmt.Errorf("state-class: unknown state %q, want one of %s or fullscreen", state, strings.Join(workspaces.States, ", "))
+		}
+	}
+	if c.DisplayOffset < 0 {
+		return fmt.Errorf("display-offset must be non-negative, got %d", c.DisplayOffset)
+	}
+	if !c.UseNames && !c.DynamicRange && c.DisplayOffset > c.StartWS {
+		return fmt.Errorf("display-offset %d would label workspace %d as %d", c.DisplayOffset, c.StartWS, c.StartWS-c.DisplayOffset)
+	}
+	if c.StartWS > c.EndWS {
+		return fmt.Errorf("start workspace %d is greater than end workspace %d", c.StartWS, c.EndWS)
+	}
+	return nil
+}
+
+// balancedParens reports whether the parentheses in the EWW expression s
+// are balanced, ignoring those in double-quoted strings. It only catches
+// user widgets that would obviously break the box.
+func balancedParens(s string) bool {
+	depth := 0
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0 && !quoted
+}
+
+// pairFlag collects FROM=TO pairs from a repeatable flag. Each value may
+// hold several comma-separated pairs so they can be set from a config file.
+type pairFlag map[string]string
+
+func (a pairFlag) String() string {
+	pairs := make([]string, 0, len(a))
+	for from, to := range a {
+		pairs = append(pairs, from+"="+to)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (a pairFlag) Set(s string) error {
+	// an empty value, e.g. as printed by --print-config, sets no pairs
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	for pair := range strings.SplitSeq(s, ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid pair %q, want FROM=TO", pair)
+		}
+		a[from] = to
+	}
+	return nil
+}
+
+// parseLogLevel parses one of debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch s {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("log-level must be debug, info, warn or error, got %q", s)
+}
+
+// parseList parses a comma-separated list, dropping empty entries. An empty
+// string yields an empty list.
+func parseList(s string) []string {
+	var fields []string
+	for field := range strings.SplitSeq(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/go-eww-workspaces/config.toml,
+// falling back to ~/.config when XDG_CONFIG_HOME is unset.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "go-eww-workspaces", "config.toml")
+}
+
+// defaultMonitorsFile returns monitors.json in the temporary directory,
+// i.e. $TMPDIR or /tmp on Linux.
+func defaultMonitorsFile() string {
+	return filepath.Join(os.TempDir(), "monitors.json")
+}
+
+// readConfigFile parses a flat TOML-style file of `key = value` lines.
+// Keys are flag names; underscores are accepted in place of dashes.
+// Comments start with '#' and values may be quoted.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		value, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseConfigValue strips quotes from a value, or a trailing comment from
+// a bare one.
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		prefix, err := strconv.QuotedPrefix(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", raw)
+		}
+		return strconv.Unquote(prefix)
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", raw)
+		}
+		return raw[1 : end+1], nil
+	default:
+		if i := strings.Index(raw, "#"); i >= 0 {
+			raw = raw[:i]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// loadConfigFile applies values from the config file at path to fset for
+// every flag that was not given explicitly on the command line. Settings
+// only flags of other have, i.e. those of another command sharing the
+// config file, are skipped. A missing file is only an error when the path
+// was requested explicitly.
+func loadConfigFile(fset, other *flag.FlagSet, path string, explicit bool) error {
+	if path == "" {
+		return nil
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	set := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for key, value := range values {
+		if key == "config" {
+			return fmt.Errorf("config file %s: %q cannot be set from a config file", path, key)
+		}
+		if set[key] || fset.Lookup(key) == nil && other.Lookup(key) != nil {
+			continue
+		}
+		if err := fset.Set(key, value); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
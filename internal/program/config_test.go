@@ -0,0 +1,126 @@
+package program
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestRendersOn(t *testing.T) {
+	tests := []struct {
+		name     string
+		onEvents []string
+		t        string
+		change   string
+		want     bool
+	}{
+		{"default workspace", nil, "workspace", "move", true},
+		{"default window", nil, "window", "title", false},
+		{"type", []string{"workspace"}, "workspace", "move", true},
+		{"other type", []string{"workspace"}, "output", "unspecified", false},
+		{"subscribed change", []string{"workspace:urgent", "workspace:focus"}, "workspace", "urgent", true},
+		{"unsubscribed change", []string{"workspace:urgent", "workspace:focus"}, "workspace", "move", false},
+		{"change of another type", []string{"workspace:focus"}, "window", "focus", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config{OnEvents: tt.onEvents}
+			if got := cfg.rendersOn(tt.t, tt.change); got != tt.want {
+				t.Errorf("rendersOn(%q, %q) with %v = %t, want %t", tt.t, tt.change, tt.onEvents, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribedEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config
+		want []string
+	}{
+		{"default", config{}, []string{"workspace", "output"}},
+		{"mode", config{ShowMode: true}, []string{"workspace", "output", "mode"}},
+		{"changes", config{OnEvents: []string{"workspace:focus", "output"}}, []string{"workspace", "output"}},
+		{"changes only", config{OnEvents: []string{"workspace:urgent"}}, []string{"workspace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.subscribedEvents(); !slices.Equal(got, tt.want) {
+				t.Errorf("subscribedEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWorkspaceChange(t *testing.T) {
+	cfg := testConfig(t, "-on-events", "workspace:urgent,workspace:focus")
+	w := &watcher{cfg: cfg, be: &swayBackend{i3Backend{cmd: "swaymsg"}}, output: "DP-1"}
+	cached := []Workspace{
+		{Num: 1, Name: "1", Focused: true, Visible: true, Output: "DP-1"},
+		{Num: 2, Name: "2", Output: "DP-1"},
+	}
+
+	w.workspaces = cached
+	urgent, err := parseEvent([]byte(`{"change":"urgent","current":{"name":"2","urgent":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.handle(context.Background(), urgent) {
+		t.Error("urgent change did not render")
+	}
+	if !w.workspaces[1].Urgent {
+		t.Error("urgent change was not applied to the cache")
+	}
+
+	w.workspaces = cached
+	move, err := parseEvent([]byte(`{"change":"move","current":{"name":"2"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.handle(context.Background(), move) {
+		t.Error("move change rendered")
+	}
+	// a move is not applied from the payload, so the next render fetches
+	if w.workspaces != nil {
+		t.Error("cache kept across a move")
+	}
+}
+
+func TestHandleWindowEventKeepsWorkspaces(t *testing.T) {
+	f := newFakeCompositor(t, `[]`)
+	cfg := testConfig(t, "-tooltips")
+	w := &watcher{cfg: cfg, be: &swayBackend{i3Backend{cmd: "swaymsg"}}, output: "DP-1", out: io.Discard}
+	w.workspaces = []Workspace{{Num: 1, Name: "1", Focused: true, Visible: true, Output: "DP-1"}}
+
+	ev, err := parseEvent([]byte(`{"change":"title","container":{"id":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.handle(context.Background(), ev) {
+		t.Fatal("window event did not render with tooltips")
+	}
+	if err := w.render(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if f.fetches != 0 {
+		t.Errorf("window event fetched the workspaces %d times, want only the tree", f.fetches)
+	}
+}
+
+func TestDefaultMonitorsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the temporary directory is not taken from $TMPDIR on Windows")
+	}
+	t.Setenv("TMPDIR", "")
+	if got := defaultMonitorsFile(); got != "/tmp/monitors.json" {
+		t.Errorf("defaultMonitorsFile() = %q without $TMPDIR, want /tmp/monitors.json", got)
+	}
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	if got, want := defaultMonitorsFile(), filepath.Join(dir, "monitors.json"); got != want {
+		t.Errorf("defaultMonitorsFile() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package program
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// feedBackend reads the workspaces from stdin or a file instead of a
+// compositor, for trying out layouts from the shell and for tools that
+// already have the workspace list. The input is a JSON array in the shape of
+// the i3/sway `get_workspaces` reply, i.e. of Workspace. A file is watched
+// for changes, which stand in for workspace events; stdin has no events.
+// There is no compositor to send click commands to.
+type feedBackend struct {
+	// path is the workspaces file, empty to read r.
+	path     string
+	interval time.Duration
+
+	r io.Reader
+	// read is set once r has been decoded into wss or failed with err,
+	// since stdin can only be read once.
+	read bool
+	wss  []Workspace
+	err  error
+}
+
+// newFeedBackend returns the backend for --workspaces-from or
+// --workspaces-file, or nil to detect the compositor.
+func newFeedBackend(cfg config) Backend {
+	switch {
+	case cfg.WorkspacesFrom == "-":
+		return &feedBackend{r: os.Stdin}
+	case cfg.WorkspacesFile != "":
+		return &feedBackend{path: cfg.WorkspacesFile, interval: cfg.FilePollInterval}
+	}
+	return nil
+}
+
+func (b *feedBackend) Name() string {
+	if b.path != "" {
+		return "file"
+	}
+	return "stdin"
+}
+
+// Workspaces reads the file on every call, waiting for it to be written
+// completely. Stdin is decoded on the first call and returned on every call.
+func (b *feedBackend) Workspaces(ctx context.Context) ([]Workspace, error) {
+	if b.path != "" {
+		var wss []Workspace
+		if err := readJSONFile(ctx, b.path, b.interval, &wss); err != nil {
+			return nil, fmt.Errorf("workspaces file: %w", err)
+		}
+		return wss, nil
+	}
+	if !b.read {
+		b.read = true
+		if err := json.NewDecoder(b.r).Decode(&b.wss); err != nil {
+			b.err = fmt.Errorf("unmarshal workspaces JSON from stdin: %w", err)
+		}
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	// callers adjust the workspaces in place
+	return slices.Clone(b.wss), nil
+}
+
+// Subscribe reports every change to the workspaces file as a workspace
+// event.
+func (b *feedBackend) Subscribe(ctx context.Context) (<-chan Event, error) {
+	if b.path == "" {
+		return nil, errors.New("no events to subscribe to when reading workspaces from stdin")
+	}
+	changed := watchFile(ctx, b.path, b.interval)
+	evCh := make(chan Event)
+	go func() {
+		defer close(evCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			select {
+			case evCh <- Event{Change: "file", kind: "workspace"}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return evCh, nil
+}
+
+func (b *feedBackend) Command(_, _ string) string { return "" }
+
+// Outputs lists the outputs named by the fed workspaces, in order of first
+// appearance, so the output can be picked without a compositor or monitors
+// file. An output is focused if one of its workspaces is.
+func (b *feedBackend) Outputs(ctx context.Context) ([]wmOutput, error) {
+	wss, err := b.Workspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []wmOutput
+	for _, ws := range wss {
+		if ws.Output == "" {
+			continue
+		}
+		i := slices.IndexFunc(outputs, func(o wmOutput) bool { return o.Name == ws.Output })
+		if i < 0 {
+			outputs = append(outputs, wmOutput{Name: ws.Output})
+			i = len(outputs) - 1
+		}
+		outputs[i].Focused = outputs[i].Focused || ws.Focused
+	}
+	return outputs, nil
+}
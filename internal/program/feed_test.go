@@ -0,0 +1,114 @@
+package program
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fedWorkspaces has workspace 2 focused on DP-1 and workspace 3 on HDMI-A-1.
+const fedWorkspaces = `[{"num":1,"name":"1","output":"DP-1"},{"num":2,"name":"2","focused":true,"visible":true,"output":"DP-1"},{"num":3,"name":"3","visible":true,"output":"HDMI-A-1"}]`
+
+// withoutCompositor hides every compositor for the duration of the test:
+// none is announced in the environment and no compositor CLI is on $PATH.
+func withoutCompositor(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"SWAYSOCK", "I3SOCK", "HYPRLAND_INSTANCE_SIGNATURE", "NIRI_SOCKET", "XDG_CURRENT_DESKTOP", monitorEnv} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("PATH", t.TempDir())
+}
+
+// renderFed runs the render command with args and returns the widget.
+func renderFed(t *testing.T, args ...string) string {
+	t.Helper()
+	lines := captureStdout(t)
+	cfg, _, err := parseFlags(append([]string{"render", "-config", "", "-end-workspace", "3"}, args...), flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := renderOnce(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	return nextLine(t, lines)
+}
+
+// feedStdin makes reply the content of os.Stdin for the duration of the test.
+func feedStdin(t *testing.T, reply string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString(reply); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	orig := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = orig
+		r.Close()
+	})
+}
+
+func TestRenderFromStdinWithoutCompositor(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// want are the classes of buttons 1 to 3, in order.
+		want []string
+	}{
+		{name: "focused output", want: []string{"occupied", "focused", "unoccupied"}},
+		{name: "monitor", args: []string{"-monitor", "HDMI-A-1"}, want: []string{"unoccupied", "unoccupied", "visible"}},
+		{name: "monitor without workspaces", args: []string{"-monitor", "DP-2"}, want: []string{"unoccupied", "unoccupied", "unoccupied"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withoutCompositor(t)
+			feedStdin(t, fedWorkspaces)
+			widget := renderFed(t, append([]string{"-workspaces-from", "-"}, tt.args...)...)
+			checkClasses(t, widget, tt.want)
+		})
+	}
+}
+
+func TestRenderFromFileWithoutCompositor(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// want are the classes of buttons 1 to 3, in order.
+		want []string
+	}{
+		{name: "focused output", want: []string{"occupied", "focused", "unoccupied"}},
+		{name: "monitor", args: []string{"-monitor", "HDMI-A-1"}, want: []string{"unoccupied", "unoccupied", "visible"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withoutCompositor(t)
+			path := filepath.Join(t.TempDir(), "workspaces.json")
+			if err := os.WriteFile(path, []byte(fedWorkspaces), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// the monitors file must not be needed either
+			args := []string{"-workspaces-file", path, "-monitors-file", filepath.Join(t.TempDir(), "monitors.json")}
+			widget := renderFed(t, append(args, tt.args...)...)
+			checkClasses(t, widget, tt.want)
+		})
+	}
+}
+
+// checkClasses fails the test unless buttons 1 to len(want) of widget have
+// the classes want.
+func checkClasses(t *testing.T, widget string, want []string) {
+	t.Helper()
+	for i, class := range want {
+		button := `:class "` + class + `" "` + string(rune('1'+i)) + `")`
+		if !strings.Contains(widget, button) {
+			t.Errorf("widget %s\nlacks %s", widget, button)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package program
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// navigation holds the flags of the goto command.
+type navigation struct {
+	// direction is "next" or "prev".
+	direction string
+	// output is the output whose workspaces are cycled, empty to resolve
+	// it from the monitor like the other commands.
+	output string
+	// wrap continues from the other end of the range past its last or
+	// first workspace.
+	wrap bool
+	// skipEmpty goes to the next or previous workspace that exists on
+	// output instead of the adjacent number.
+	skipEmpty bool
+}
+
+// runGoto switches to the workspace after or before the focused one on the
+// output, within the configured range. It centralises navigation so EWW
+// handlers call back into this binary instead of embedding shell
+// arithmetic.
+func runGoto(ctx context.Context, cfg config, nav navigation) error {
+	be, err := detectBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	output := nav.output
+	if output == "" {
+		w := &watcher{cfg: cfg, be: be}
+		if err := w.refresh(ctx); err != nil {
+			return err
+		}
+		output = w.output
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, cfg.FetchTimeout)
+	defer cancel()
+	wss, err := fetchWorkspaces(fetchCtx, be, cfg)
+	if err != nil {
+		return explainTimeout(err, "compositor did not respond", cfg.FetchTimeout, "fetch-timeout")
+	}
+	for i := range wss {
+		wss[i].Output = cfg.canonicalOutput(wss[i].Output)
+	}
+
+	target, ok := gotoTarget(wss, output, cfg, nav)
+	if !ok {
+		slog.Debug("no workspace to go to", "output", output, "direction", nav.direction)
+		return nil
+	}
+	cmd := be.Command("switch", strconv.Itoa(target))
+	if cmd == "" {
+		return fmt.Errorf("%s cannot switch workspaces", be.Name())
+	}
+	if _, err := commandOutput(ctx, "sh", "-c", cmd); err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	return nil
+}
+
+// gotoTarget returns the workspace number after or before the one shown on
+// output, or false if there is none to go to. The focused workspace counts
+// over a visible one, so this also works for the focused output.
+func gotoTarget(wss []Workspace, output string, cfg config, nav navigation) (int, bool) {
+	i := slices.IndexFunc(wss, func(ws Workspace) bool { return ws.Output == output && ws.Focused })
+	if i < 0 {
+		i = slices.IndexFunc(wss, func(ws Workspace) bool { return ws.Output == output && ws.Visible })
+	}
+	if i < 0 {
+		return 0, false
+	}
+	current := wss[i].Num
+
+	var candidates []int
+	if nav.skipEmpty {
+		for _, ws := range wss {
+			if ws.Output == output && ws.Num >= cfg.StartWS && ws.Num <= cfg.EndWS {
+				candidates = append(candidates, ws.Num)
+			}
+		}
+		slices.Sort(candidates)
+	} else {
+		for num := cfg.StartWS; num <= cfg.EndWS; num++ {
+			candidates = append(candidates, num)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	var target int
+	if nav.direction == "prev" {
+		// the last candidate before current, else the last one
+		j := slices.IndexFunc(candidates, func(num int) bool { return num >= current })
+		switch {
+		case j > 0:
+			target = candidates[j-1]
+		case j < 0 && candidates[len(candidates)-1] < current:
+			target = candidates[len(candidates)-1]
+		case !nav.wrap:
+			return 0, false
+		default:
+			target = candidates[len(candidates)-1]
+		}
+	} else {
+		// the first candidate after current, else the first one
+		j := slices.IndexFunc(candidates, func(num int) bool { return num > current })
+		switch {
+		case j >= 0:
+			target = candidates[j]
+		case !nav.wrap:
+			return 0, false
+		default:
+			target = candidates[0]
+		}
+	}
+	return target, target != current
+}
+
+// gotoCommand returns the :onscroll handler calling back into the goto
+// command, which wraps around the range. EWW substitutes {} with the scroll
+// direction, which goto accepts.
+func gotoCommand(output string, cfg config) string {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	// the config file is skipped so only these flags decide the range
+	cmd := fmt.Sprintf("'%s' goto -config '' -direction {} -wrap -output '%s' -start-workspace %d -end-workspace %d", self, output, cfg.StartWS, cfg.EndWS)
+	if cfg.Socket != "" {
+		cmd += fmt.Sprintf(" -socket '%s'", cfg.Socket)
+	}
+	return cmd
+}
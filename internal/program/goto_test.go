@@ -0,0 +1,56 @@
+package program
+
+import "testing"
+
+func TestGotoTarget(t *testing.T) {
+	cfg := config{StartWS: 1, EndWS: 5}
+	// on returns workspaces on DP-1 with the given numbers, the first one
+	// focused
+	on := func(nums ...int) []Workspace {
+		var wss []Workspace
+		for i, num := range nums {
+			wss = append(wss, Workspace{Num: num, Focused: i == 0, Visible: i == 0, Output: "DP-1"})
+		}
+		return wss
+	}
+	tests := []struct {
+		name string
+		wss  []Workspace
+		nav  navigation
+		want int
+		ok   bool
+	}{
+		{"next", on(2), navigation{direction: "next"}, 3, true},
+		{"prev", on(2), navigation{direction: "prev"}, 1, true},
+		{"next at the end", on(5), navigation{direction: "next"}, 0, false},
+		{"next wraps", on(5), navigation{direction: "next", wrap: true}, 1, true},
+		{"prev at the start", on(1), navigation{direction: "prev"}, 0, false},
+		{"prev wraps", on(1), navigation{direction: "prev", wrap: true}, 5, true},
+		{"skip empty next", on(1, 3, 5), navigation{direction: "next", skipEmpty: true}, 3, true},
+		{"skip empty prev", on(5, 1, 3), navigation{direction: "prev", skipEmpty: true}, 3, true},
+		{"skip empty next wraps", on(5, 1, 3), navigation{direction: "next", skipEmpty: true, wrap: true}, 1, true},
+		{"skip empty prev wraps", on(1, 3, 5), navigation{direction: "prev", skipEmpty: true, wrap: true}, 5, true},
+		{"skip empty alone", on(3), navigation{direction: "next", skipEmpty: true, wrap: true}, 0, false},
+		{"no candidates", on(7, 9), navigation{direction: "next", skipEmpty: true, wrap: true}, 0, false},
+		{"current past candidates next", on(7, 2, 4), navigation{direction: "next", skipEmpty: true}, 0, false},
+		{"current past candidates next wraps", on(7, 2, 4), navigation{direction: "next", skipEmpty: true, wrap: true}, 2, true},
+		{"current past candidates prev", on(7, 2, 4), navigation{direction: "prev", skipEmpty: true}, 4, true},
+		{"current past range prev", on(7), navigation{direction: "prev"}, 5, true},
+		{"nothing on output", []Workspace{{Num: 1, Focused: true, Output: "HDMI-A-1"}}, navigation{direction: "next", wrap: true}, 0, false},
+		{
+			"visible on unfocused output",
+			[]Workspace{{Num: 1, Focused: true, Visible: true, Output: "HDMI-A-1"}, {Num: 4, Visible: true, Output: "DP-1"}},
+			navigation{direction: "next"},
+			5,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := gotoTarget(tt.wss, "DP-1", cfg, tt.nav)
+			if ok != tt.ok || ok && got != tt.want {
+				t.Errorf("gotoTarget = %d, %t, want %d, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
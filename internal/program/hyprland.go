@@ -0,0 +1,288 @@
+package program
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// hyprWorkspace is the subset of `hyprctl workspaces -j` we care about.
+type hyprWorkspace struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Monitor string `json:"monitor"`
+	Windows int    `json:"windows"`
+}
+
+// hyprMonitor is the subset of `hyprctl monitors -j` we care about.
+type hyprMonitor struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Focused         bool   `json:"focused"`
+	ActiveWorkspace struct {
+		ID int `json:"id"`
+	} `json:"activeWorkspace"`
+}
+
+// hyprClient is the subset of `hyprctl clients -j` we care about.
+type hyprClient struct {
+	Address   string `json:"address"`
+	Workspace struct {
+		ID int `json:"id"`
+	} `json:"workspace"`
+}
+
+// hyprBackend talks to Hyprland through hyprctl and its event socket.
+type hyprBackend struct {
+	cmd string
+
+	// urgent holds the addresses of the windows that raised an urgent
+	// event, without the 0x prefix. Hyprland does not report urgency in
+	// its workspace list, so it is tracked from the event socket.
+	mu     sync.Mutex
+	urgent map[string]bool
+}
+
+// detectHyprland returns a Hyprland backend when a Hyprland instance is
+// advertised and hyprctl can reach it.
+func detectHyprland(ctx context.Context, _ config) (Backend, bool) {
+	if os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") == "" {
+		return nil, false
+	}
+	hyprPath, err := lookPath("hyprctl")
+	if err != nil {
+		return nil, false
+	}
+	if _, err := commandOutput(ctx, hyprPath, "version"); err != nil {
+		return nil, false
+	}
+	return &hyprBackend{cmd: hyprPath}, true
+}
+
+func (b *hyprBackend) Name() string { return "hyprland" }
+
+func (b *hyprBackend) Workspaces(ctx context.Context) ([]Workspace, error) {
+	wss, err := fetchHyprWorkspaces(ctx, b.cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.markUrgent(ctx, wss); err != nil {
+		return nil, err
+	}
+	return wss, nil
+}
+
+// markUrgent sets Urgent on the workspaces holding a window that raised an
+// urgent event. Urgency ends once the workspace is focused or the window
+// closes, mirroring i3.
+func (b *hyprBackend) markUrgent(ctx context.Context, wss []Workspace) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.urgent) == 0 {
+		return nil
+	}
+	var clients []hyprClient
+	if err := hyprctlJSON(ctx, b.cmd, &clients, "clients"); err != nil {
+		return err
+	}
+	open := make(map[string]bool, len(clients))
+	for _, c := range clients {
+		addr := strings.TrimPrefix(c.Address, "0x")
+		open[addr] = true
+		if !b.urgent[addr] {
+			continue
+		}
+		for i := range wss {
+			if wss[i].Num != c.Workspace.ID {
+				continue
+			}
+			if wss[i].Focused {
+				delete(b.urgent, addr)
+			} else {
+				wss[i].Urgent = true
+			}
+		}
+	}
+	for addr := range b.urgent {
+		if !open[addr] {
+			delete(b.urgent, addr)
+		}
+	}
+	return nil
+}
+
+// Subscribe reads the Hyprland event socket.
+func (b *hyprBackend) Subscribe(ctx context.Context) (<-chan Event, error) {
+	conn, err := dialHyprEvents()
+	if err != nil {
+		return nil, err
+	}
+	// unblock the reader on shutdown
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	done := func() {
+		stop()
+		conn.Close()
+	}
+	parse := func(line []byte) (Event, error) {
+		ev, err := parseHyprEvent(line)
+		if err == nil && ev.Change == "urgent" {
+			_, addr, _ := bytes.Cut(line, []byte(">>"))
+			b.mu.Lock()
+			if b.urgent == nil {
+				b.urgent = make(map[string]bool)
+			}
+			b.urgent[strings.TrimPrefix(string(addr), "0x")] = true
+			b.mu.Unlock()
+		}
+		return ev, err
+	}
+	return streamEvents(ctx, conn, parse, done), nil
+}
+
+// Outputs returns the monitors, which hyprctl only lists while they are
+// active.
+func (b *hyprBackend) Outputs(ctx context.Context) ([]wmOutput, error) {
+	var mons []hyprMonitor
+	if err := hyprctlJSON(ctx, b.cmd, &mons, "monitors"); err != nil {
+		return nil, err
+	}
+	outputs := make([]wmOutput, 0, len(mons))
+	for _, m := range mons {
+		outputs = append(outputs, wmOutput{Name: m.Name, Description: m.Description, Focused: m.Focused})
+	}
+	return outputs, nil
+}
+
+func (b *hyprBackend) Command(action, target string) string {
+	switch action {
+	case "":
+		return b.cmd + " dispatch"
+	case "switch":
+		return fmt.Sprintf("%s dispatch 'workspace %s'", b.cmd, target)
+	case "move":
+		return fmt.Sprintf("%s dispatch 'movetoworkspacesilent %s'", b.cmd, target)
+	case "next":
+		return fmt.Sprintf("%s --batch 'dispatch focusmonitor %s; dispatch workspace m+1'", b.cmd, target)
+	case "prev":
+		return fmt.Sprintf("%s --batch 'dispatch focusmonitor %s; dispatch workspace m-1'", b.cmd, target)
+	case "scratchpad":
+		return fmt.Sprintf("%s dispatch togglespecialworkspace", b.cmd)
+	}
+	return ""
+}
+
+// hyprctlJSON runs `hyprctl <args...> -j` and decodes the reply into v.
+func hyprctlJSON(ctx context.Context, cmdName string, v any, args ...string) error {
+	out, err := commandOutput(ctx, cmdName, append(args, "-j")...)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", cmdName, strings.Join(args, " "), err)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("unmarshal %s JSON: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// fetchHyprWorkspaces retrieves Hyprland workspaces and adapts them to the
+// i3/sway Workspace shape. Focus comes from the focused monitor's active
+// workspace since `hyprctl workspaces` does not report it.
+func fetchHyprWorkspaces(ctx context.Context, cmdName string) ([]Workspace, error) {
+	var hws []hyprWorkspace
+	if err := hyprctlJSON(ctx, cmdName, &hws, "workspaces"); err != nil {
+		return nil, err
+	}
+	var mons []hyprMonitor
+	if err := hyprctlJSON(ctx, cmdName, &mons, "monitors"); err != nil {
+		return nil, err
+	}
+
+	focusedID := 0
+	shown := make(map[int]bool, len(mons))
+	for _, m := range mons {
+		shown[m.ActiveWorkspace.ID] = true
+		if m.Focused {
+			focusedID = m.ActiveWorkspace.ID
+		}
+	}
+
+	wss := make([]Workspace, 0, len(hws))
+	for _, hw := range hws {
+		wss = append(wss, Workspace{
+			Name:    hw.Name,
+			Num:     hw.ID,
+			Focused: hw.ID == focusedID,
+			Visible: shown[hw.ID],
+			Output:  hw.Monitor,
+		})
+	}
+	return wss, nil
+}
+
+// dialHyprEvents connects to the Hyprland event socket for the running
+// instance, trying the current runtime dir location before the legacy /tmp one.
+func dialHyprEvents() (net.Conn, error) {
+	sig := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if sig == "" {
+		return nil, fmt.Errorf("HYPRLAND_INSTANCE_SIGNATURE is not set")
+	}
+
+	var candidates []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "hypr", sig, ".socket2.sock"))
+	}
+	candidates = append(candidates, filepath.Join("/tmp", "hypr", sig, ".socket2.sock"))
+
+	var lastErr error
+	for _, path := range candidates {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("connect to hyprland event socket: %w", lastErr)
+}
+
+// hyprEventTypes maps Hyprland event names to the i3/sway subscription
+// they correspond to. Unlisted events are ignored.
+var hyprEventTypes = map[string]string{
+	"workspace":          "workspace",
+	"workspacev2":        "workspace",
+	"focusedmon":         "workspace",
+	"createworkspace":    "workspace",
+	"createworkspacev2":  "workspace",
+	"destroyworkspace":   "workspace",
+	"destroyworkspacev2": "workspace",
+	"moveworkspace":      "workspace",
+	"moveworkspacev2":    "workspace",
+	"renameworkspace":    "workspace",
+	"urgent":             "workspace",
+	"openwindow":         "window",
+	"closewindow":        "window",
+	"movewindow":         "window",
+	"movewindowv2":       "window",
+	"activewindow":       "window",
+	"monitoradded":       "output",
+	"monitoraddedv2":     "output",
+	"monitorremoved":     "output",
+}
+
+// parseHyprEvent converts a `name>>data` line from the Hyprland event socket
+// into an Event.
+func parseHyprEvent(line []byte) (Event, error) {
+	name, _, ok := bytes.Cut(line, []byte(">>"))
+	if !ok {
+		return Event{}, fmt.Errorf("unrecognized hyprland event %q", line)
+	}
+	kind, ok := hyprEventTypes[string(name)]
+	if !ok {
+		return Event{}, nil
+	}
+	return Event{Change: string(name), kind: kind}, nil
+}
@@ -0,0 +1,282 @@
+package program
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// i3Backend talks to i3 over its IPC socket, or through i3-msg when the
+// socket is unknown. Click commands always go through i3-msg.
+type i3Backend struct {
+	cmd string
+	// socket is the IPC socket path, empty to query through cmd.
+	socket string
+	// events are the event types subscribed to.
+	events []string
+	// pinned is set when the socket was given with --socket, which click
+	// commands then pass on.
+	pinned bool
+}
+
+// swayBackend talks to sway, which speaks the same IPC as i3.
+type swayBackend struct {
+	i3Backend
+}
+
+// detectSway returns a sway backend if --socket, $SWAYSOCK or swaymsg can
+// reach a running sway.
+func detectSway(ctx context.Context, cfg config) (Backend, bool) {
+	if cfg.Socket != "" {
+		// i3 answers on the same protocol, so check which one this is
+		version, err := ipcQuery(ctx, cfg.Socket, ipcMessageTypes["get_version"], nil)
+		if err != nil || !isSwayVersion(version) {
+			return nil, false
+		}
+		return &swayBackend{i3Backend{cmd: cliPath("swaymsg"), socket: cfg.Socket, events: cfg.subscribedEvents(), pinned: true}}, true
+	}
+	if socket := os.Getenv("SWAYSOCK"); socket != "" {
+		if _, err := ipcQuery(ctx, socket, ipcMessageTypes["get_version"], nil); err == nil {
+			return &swayBackend{i3Backend{cmd: cliPath("swaymsg"), socket: socket, events: cfg.subscribedEvents()}}, true
+		}
+	}
+	swayPath, err := lookPath("swaymsg")
+	if err != nil {
+		return nil, false
+	}
+	// verify it really is a sway instance
+	if _, err := commandOutput(ctx, swayPath, "-t", "get_version"); err != nil {
+		return nil, false
+	}
+	return &swayBackend{i3Backend{cmd: swayPath, events: cfg.subscribedEvents()}}, true
+}
+
+// detectI3 returns an i3 backend if --socket or $I3SOCK reaches a running
+// i3 or i3-msg is installed.
+func detectI3(ctx context.Context, cfg config) (Backend, bool) {
+	if cfg.Socket != "" {
+		if _, err := ipcQuery(ctx, cfg.Socket, ipcMessageTypes["get_version"], nil); err != nil {
+			return nil, false
+		}
+		return &i3Backend{cmd: cliPath("i3-msg"), socket: cfg.Socket, events: cfg.subscribedEvents(), pinned: true}, true
+	}
+	if socket := os.Getenv("I3SOCK"); socket != "" {
+		if _, err := ipcQuery(ctx, socket, ipcMessageTypes["get_version"], nil); err == nil {
+			return &i3Backend{cmd: cliPath("i3-msg"), socket: socket, events: cfg.subscribedEvents()}, true
+		}
+	}
+	i3Path, err := lookPath("i3-msg")
+	if err != nil {
+		return nil, false
+	}
+	return &i3Backend{cmd: i3Path, events: cfg.subscribedEvents()}, true
+}
+
+// isSwayVersion reports whether a `get_version` reply comes from sway.
+func isSwayVersion(reply []byte) bool {
+	var version struct {
+		Variant string `json:"variant"`
+	}
+	return json.Unmarshal(reply, &version) == nil && version.Variant == "sway"
+}
+
+// cliPath returns the full path of name if it is on PATH, else name itself.
+func cliPath(name string) string {
+	if path, err := lookPath(name); err == nil {
+		return path
+	}
+	return name
+}
+
+// query fetches the reply to an IPC message named as for `i3-msg -t`, over
+// the socket when known and through the CLI otherwise.
+func (b *i3Backend) query(ctx context.Context, msgType string) ([]byte, error) {
+	if b.socket == "" {
+		out, err := commandOutput(ctx, b.cmd, "-t", msgType)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", b.cmd, msgType, err)
+		}
+		return out, nil
+	}
+	out, err := ipcQuery(ctx, b.socket, ipcMessageTypes[msgType], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", b.socket, msgType, err)
+	}
+	return out, nil
+}
+
+func (b *i3Backend) Name() string { return "i3" }
+
+func (b *swayBackend) Name() string { return "sway" }
+
+// Tree queries `get_tree`.
+func (b *i3Backend) Tree(ctx context.Context) (treeNode, error) {
+	return fetchTree(ctx, b.query)
+}
+
+// Assignments reads the workspace output assignments via `get_config`.
+func (b *i3Backend) Assignments(ctx context.Context) (map[string]string, error) {
+	return fetchAssignments(ctx, b.query)
+}
+
+// Outputs queries `get_outputs` and returns the active outputs.
+func (b *i3Backend) Outputs(ctx context.Context) ([]wmOutput, error) {
+	out, err := b.query(ctx, "get_outputs")
+	if err != nil {
+		return nil, err
+	}
+	var outputs []struct {
+		Name    string `json:"name"`
+		Make    string `json:"make"`
+		Model   string `json:"model"`
+		Serial  string `json:"serial"`
+		Active  bool   `json:"active"`
+		Focused bool   `json:"focused"`
+	}
+	if err := json.Unmarshal(out, &outputs); err != nil {
+		return nil, fmt.Errorf("unmarshal outputs JSON: %w", err)
+	}
+	var active []wmOutput
+	for _, o := range outputs {
+		if o.Active {
+			active = append(active, wmOutput{
+				Name:        o.Name,
+				Description: strings.Join([]string{o.Make, o.Model, o.Serial}, " "),
+				Focused:     o.Focused,
+			})
+		}
+	}
+	return active, nil
+}
+
+// Mode queries `get_binding_state` for the current binding mode.
+func (b *i3Backend) Mode(ctx context.Context) (string, error) {
+	out, err := b.query(ctx, "get_binding_state")
+	if err != nil {
+		return "", err
+	}
+	var state struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(out, &state); err != nil {
+		return "", fmt.Errorf("unmarshal binding state JSON: %w", err)
+	}
+	return state.Name, nil
+}
+
+// Workspaces queries `get_workspaces`.
+func (b *i3Backend) Workspaces(ctx context.Context) ([]Workspace, error) {
+	out, err := b.query(ctx, "get_workspaces")
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, fmt.Errorf("get_workspaces: %w", errEmptyReply)
+	}
+	var wss []Workspace
+	if err := json.Unmarshal(out, &wss); err != nil {
+		return nil, fmt.Errorf("unmarshal workspaces JSON: %w", err)
+	}
+	return wss, nil
+}
+
+// Subscribe runs `subscribe` for the configured event types and checks the
+// reply acknowledging it. The subscribe process is killed when ctx is
+// cancelled.
+func (b *i3Backend) Subscribe(ctx context.Context) (<-chan Event, error) {
+	events, err := json.Marshal(b.events)
+	if err != nil {
+		return nil, err
+	}
+	stdout, waitCmd, err := commandStream(ctx, b.cmd, "-t", "subscribe", "-m", string(events))
+	if err != nil {
+		return nil, err
+	}
+	// reap the subscribe process so it does not linger as a zombie
+	wait := func() {
+		if err := waitCmd(); err != nil && ctx.Err() == nil {
+			slog.Warn("subscribe process exited", "cmd", b.cmd, "err", err)
+		}
+	}
+
+	r := bufio.NewReader(stdout)
+	ack, err := r.ReadBytes('\n')
+	if err != nil {
+		// the caller cancels ctx, which ends the process
+		go waitCmd()
+		return nil, fmt.Errorf("%s subscribe exited before acknowledging: %w", b.cmd, err)
+	}
+	var reply struct {
+		Success bool `json:"success"`
+	}
+	if err := json.Unmarshal(ack, &reply); err != nil || !reply.Success {
+		go waitCmd()
+		return nil, fmt.Errorf("%w: %s replied %s", errSubscribeRejected, b.cmd, bytes.TrimSpace(ack))
+	}
+	return streamEvents(ctx, r, parseEvent, wait), nil
+}
+
+func (b *i3Backend) Command(action, target string) string {
+	cmd := b.cmd
+	if b.pinned {
+		// click commands run from EWW, outside our environment
+		cmd = fmt.Sprintf("%s -s '%s'", b.cmd, b.socket)
+	}
+	switch action {
+	case "":
+		return cmd
+	case "switch":
+		return fmt.Sprintf("%s 'workspace %s'", cmd, target)
+	case "move":
+		return fmt.Sprintf("%s 'move container to workspace %s'", cmd, target)
+	case "next":
+		return fmt.Sprintf("%s 'focus output %s; workspace next_on_output'", cmd, target)
+	case "prev":
+		return fmt.Sprintf("%s 'focus output %s; workspace prev_on_output'", cmd, target)
+	case "scratchpad":
+		return fmt.Sprintf("%s 'scratchpad show'", cmd)
+	}
+	return ""
+}
+
+// Apply updates wss for focus and urgency changes, which carry the affected
+// workspace in their payload. Other changes create, remove or move
+// workspaces and need a full fetch.
+func (b *i3Backend) Apply(wss []Workspace, ev Event) ([]Workspace, bool) {
+	if ev.Change != "focus" && ev.Change != "urgent" {
+		return nil, false
+	}
+	var current struct {
+		Name   string `json:"name"`
+		Urgent bool   `json:"urgent"`
+	}
+	if err := json.Unmarshal(ev.Current, &current); err != nil {
+		return nil, false
+	}
+	i := slices.IndexFunc(wss, func(ws Workspace) bool { return ws.Name == current.Name })
+	if i < 0 {
+		return nil, false
+	}
+
+	wss = slices.Clone(wss)
+	if ev.Change == "urgent" {
+		wss[i].Urgent = current.Urgent
+		return wss, true
+	}
+	for j := range wss {
+		wss[j].Focused = j == i
+		// focusing a workspace shows it in place of the one on its output
+		if wss[j].Output == wss[i].Output {
+			wss[j].Visible = j == i
+		}
+	}
+	// i3 clears urgency once a workspace is focused
+	wss[i].Urgent = current.Urgent
+	return wss, true
+}
@@ -0,0 +1,75 @@
+package program
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ipcMagic starts every message on the i3/sway IPC socket.
+const ipcMagic = "i3-ipc"
+
+// ipcMessageTypes maps the message names accepted by `i3-msg -t` to their
+// IPC type numbers, see https://i3wm.org/docs/ipc.html.
+var ipcMessageTypes = map[string]uint32{
+	"get_workspaces":    1,
+	"get_outputs":       3,
+	"get_tree":          4,
+	"get_version":       7,
+	"get_config":        9,
+	"get_binding_state": 12,
+}
+
+// ipcQuery sends one message of msgType to the i3/sway IPC socket at path
+// and returns the reply payload.
+func ipcQuery(ctx context.Context, path string, msgType uint32, payload []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// unblock reads and writes once ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	reply, err := ipcRoundTrip(conn, msgType, payload)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+// ipcRoundTrip writes a message to rw and reads the reply to it.
+func ipcRoundTrip(rw io.ReadWriter, msgType uint32, payload []byte) ([]byte, error) {
+	msg := make([]byte, 0, len(ipcMagic)+8+len(payload))
+	msg = append(msg, ipcMagic...)
+	msg = binary.NativeEndian.AppendUint32(msg, uint32(len(payload)))
+	msg = binary.NativeEndian.AppendUint32(msg, msgType)
+	msg = append(msg, payload...)
+	if _, err := rw.Write(msg); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(ipcMagic)+8)
+	if _, err := io.ReadFull(rw, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(ipcMagic)]) != ipcMagic {
+		return nil, errors.New("invalid IPC reply magic")
+	}
+	size := binary.NativeEndian.Uint32(header[len(ipcMagic):])
+	replyType := binary.NativeEndian.Uint32(header[len(ipcMagic)+4:])
+	if replyType != msgType {
+		return nil, fmt.Errorf("IPC reply type %d does not match request type %d", replyType, msgType)
+	}
+	reply := make([]byte, size)
+	if _, err := io.ReadFull(rw, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,69 @@
+package program
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// metrics counts what the watcher did, for diagnosing render frequency in
+// the field. The counters are atomic since they are dumped from the signal
+// handler while the watcher runs.
+type metrics struct {
+	events      atomic.Int64
+	renders     atomic.Int64
+	unchanged   atomic.Int64
+	fetchErrors atomic.Int64
+	reconnects  atomic.Int64
+	overflows   atomic.Int64
+}
+
+// dump writes the counters to out in the Prometheus text format, so the
+// output can be written into a node exporter textfile directory.
+func (m *metrics) dump(out io.Writer) {
+	fmt.Fprintf(out, `go_eww_workspaces_events_received_total %d
+go_eww_workspaces_renders_total %d
+go_eww_workspaces_renders_unchanged_total %d
+go_eww_workspaces_fetch_errors_total %d
+go_eww_workspaces_reconnects_total %d
+go_eww_workspaces_event_overflows_total %d
+`, m.events.Load(), m.renders.Load(), m.unchanged.Load(), m.fetchErrors.Load(), m.reconnects.Load(), m.overflows.Load())
+}
+
+// reset zeroes the counters.
+func (m *metrics) reset() {
+	m.events.Store(0)
+	m.renders.Store(0)
+	m.unchanged.Store(0)
+	m.fetchErrors.Store(0)
+	m.reconnects.Store(0)
+	m.overflows.Store(0)
+}
+
+// dumpOnSignal dumps m to stderr on every SIGUSR1 and resets it on every
+// SIGUSR2 until ctx is cancelled. The signals are caught from the time it
+// returns, so a signal sent right after startup does not kill the process.
+func (m *metrics) dumpOnSignal(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigs:
+				if sig == syscall.SIGUSR2 {
+					m.reset()
+					continue
+				}
+				// stderr, like the logs, so the widgets on stdout stay intact
+				m.dump(os.Stderr)
+			}
+		}
+	}()
+}
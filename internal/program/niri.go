@@ -0,0 +1,130 @@
+package program
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// niriWorkspace is the subset of `niri msg --json workspaces` we care about.
+type niriWorkspace struct {
+	ID             uint64  `json:"id"`
+	Idx            int     `json:"idx"`
+	Name           *string `json:"name"`
+	Output         *string `json:"output"`
+	IsUrgent       bool    `json:"is_urgent"`
+	IsActive       bool    `json:"is_active"`
+	IsFocused      bool    `json:"is_focused"`
+	ActiveWindowID *uint64 `json:"active_window_id"`
+}
+
+// niriBackend talks to niri through `niri msg`.
+type niriBackend struct {
+	cmd string
+}
+
+// detectNiri returns a niri backend when a niri instance is advertised.
+func detectNiri(ctx context.Context, _ config) (Backend, bool) {
+	if os.Getenv("NIRI_SOCKET") == "" {
+		return nil, false
+	}
+	niriPath, err := lookPath("niri")
+	if err != nil {
+		return nil, false
+	}
+	if _, err := commandOutput(ctx, niriPath, "msg", "version"); err != nil {
+		return nil, false
+	}
+	return &niriBackend{cmd: niriPath}, true
+}
+
+func (b *niriBackend) Name() string { return "niri" }
+
+// Workspaces runs `niri msg --json workspaces`. niri numbers workspaces per
+// output by position, which becomes Num; the empty workspace niri keeps at
+// the end of every output is dropped unless it is active.
+func (b *niriBackend) Workspaces(ctx context.Context) ([]Workspace, error) {
+	out, err := commandOutput(ctx, b.cmd, "msg", "--json", "workspaces")
+	if err != nil {
+		return nil, fmt.Errorf("%s msg workspaces: %w", b.cmd, err)
+	}
+	var nws []niriWorkspace
+	if err := json.Unmarshal(out, &nws); err != nil {
+		return nil, fmt.Errorf("unmarshal workspaces JSON: %w", err)
+	}
+
+	wss := make([]Workspace, 0, len(nws))
+	for _, nw := range nws {
+		if nw.ActiveWindowID == nil && !nw.IsActive {
+			continue
+		}
+		ws := Workspace{
+			Name:    strconv.Itoa(nw.Idx),
+			Num:     nw.Idx,
+			Focused: nw.IsFocused,
+			Visible: nw.IsActive,
+			Urgent:  nw.IsUrgent,
+		}
+		if nw.Name != nil {
+			ws.Name = *nw.Name
+		}
+		if nw.Output != nil {
+			ws.Output = *nw.Output
+		}
+		wss = append(wss, ws)
+	}
+	return wss, nil
+}
+
+// Subscribe runs `niri msg --json event-stream`.
+func (b *niriBackend) Subscribe(ctx context.Context) (<-chan Event, error) {
+	stdout, waitCmd, err := commandStream(ctx, b.cmd, "msg", "--json", "event-stream")
+	if err != nil {
+		return nil, err
+	}
+	wait := func() {
+		if err := waitCmd(); err != nil && ctx.Err() == nil {
+			slog.Warn("event-stream process exited", "cmd", b.cmd, "err", err)
+		}
+	}
+	return streamEvents(ctx, stdout, parseNiriEvent, wait), nil
+}
+
+// parseNiriEvent converts a line of the niri event stream, an object with a
+// single key naming the event, into an Event. Workspace events map to
+// "workspace" and window events to "window"; others are ignored.
+func parseNiriEvent(line []byte) (Event, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Event{}, err
+	}
+	for name := range raw {
+		switch {
+		case strings.HasPrefix(name, "Workspace"):
+			return Event{Change: name, kind: "workspace"}, nil
+		case strings.HasPrefix(name, "Window"):
+			return Event{Change: name, kind: "window"}, nil
+		}
+	}
+	return Event{}, nil
+}
+
+func (b *niriBackend) Command(action, target string) string {
+	switch action {
+	case "":
+		return b.cmd + " msg action"
+	case "switch":
+		return fmt.Sprintf("%s msg action focus-workspace '%s'", b.cmd, target)
+	case "move":
+		return fmt.Sprintf("%s msg action move-window-to-workspace --focus false '%s'", b.cmd, target)
+	case "next":
+		return fmt.Sprintf("%[1]s msg action focus-monitor '%[2]s' && %[1]s msg action focus-workspace-down", b.cmd, target)
+	case "prev":
+		return fmt.Sprintf("%[1]s msg action focus-monitor '%[2]s' && %[1]s msg action focus-workspace-up", b.cmd, target)
+	}
+	return ""
+}
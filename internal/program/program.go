@@ -1,253 +1,1854 @@
 package program
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/qikiqi/go-eww-workspaces/internal/version"
+	"github.com/qikiqi/go-eww-workspaces/workspaces"
 )
 
 const (
-	startWS   = 1
-	endWS     = 10
-	ewwFormat = `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" %s)`
-	btnFormat = `(button :onclick "%s 'workspace %d'" :visible %t :class "%s" "%d")`
+	defaultStartWS = 1
+	defaultEndWS   = 10
+	ewwFormat      = `(box :class "%s" :orientation "%s" :halign "%s"%s :spacing "%d" :space-evenly "%t" %s)`
+	btnTemplate    = `(button :onclick "{{.OnClick}}"{{with .OnMiddleClick}} :onmiddleclick "{{.}}"{{end}}{{with .OnRightClick}} :onrightclick "{{.}}"{{end}}{{with .Tooltip}} :tooltip "{{.}}"{{end}} :visible {{.Visible}} :class "{{.State}}" "{{.Label}}")`
+	scratchFormat  = `(button :onclick "%s" :visible %t :class "scratchpad" "%s")`
+	modeFormat     = `(label :visible %t :class "mode" :text "%s")`
+	scrollFormat   = `(eventbox :onscroll "%s" %s)`
+
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+
+	// monitorEnv names the environment variable consulted when no monitor
+	// is configured, for per-monitor EWW deflisten setups.
+	monitorEnv = "EWW_MONITOR"
+)
+
+// Exit codes of Run, letting wrapper scripts tell failures apart.
+const (
+	exitError           = 1
+	exitNoMonitor       = 2
+	exitMonitorsFile    = 3
+	exitMonitorNotFound = 4
+	exitNoCompositor    = 5
+	exitUsage           = 6
+)
+
+var (
+	errNoCompositor    = errors.New("no supported compositor found")
+	errNoMonitor       = errors.New("no monitor specified and autodetection failed")
+	errMonitorsFile    = errors.New("cannot read monitors file")
+	errMonitorNotFound = errors.New("monitor not found")
+	// errUsage marks errors in the command line or config file.
+	errUsage = errors.New("invalid usage")
+	// errEmptyReply is returned for a reply with no body at all, which
+	// i3/sway send transiently while reloading.
+	errEmptyReply = errors.New("empty reply")
+	// errSubscribeRejected is returned when the compositor refuses a
+	// subscription, which retrying will not change.
+	errSubscribeRejected = errors.New("subscription rejected")
+	// errResubscribe is returned by watch when a reload changed the event
+	// types to subscribe to.
+	errResubscribe = errors.New("subscribed events changed")
 )
 
+// healthySubscription is how long a subscription has to last for the
+// reconnect backoff and count to start over. It is a variable so tests can
+// shorten it.
+var healthySubscription = time.Minute
+
+// exitCode returns the exit code for an error returned while running.
+func exitCode(err error) int {
+	var execErr *exec.Error
+	switch {
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errNoMonitor):
+		// before the compositor, whose CLI autodetection may have missed
+		return exitNoMonitor
+	case errors.Is(err, errNoCompositor):
+		return exitNoCompositor
+	case errors.As(err, &execErr) && errors.Is(execErr.Err, exec.ErrNotFound) && slices.Contains(compositorCLIs, filepath.Base(execErr.Name)):
+		// a missing compositor CLI means none can be running, unlike
+		// other missing commands such as eww
+		return exitNoCompositor
+	case errors.Is(err, errMonitorsFile):
+		return exitMonitorsFile
+	case errors.Is(err, errMonitorNotFound):
+		return exitMonitorNotFound
+	}
+	return exitError
+}
+
+// explainTimeout prefixes err, if it is a deadline error, with what did not
+// happen within timeout and the flag raising the timeout, since "context
+// deadline exceeded" alone does not say which step was slow.
+func explainTimeout(err error, what string, timeout time.Duration, flag string) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%s within %v (try --%s): %w", what, timeout, flag, err)
+}
+
+// Workspace and ButtonState are shared with the workspaces package, which
+// computes the buttons.
+type (
+	Workspace   = workspaces.Workspace
+	ButtonState = workspaces.ButtonState
+)
+
+// parseButtonTemplate compiles the button or label template named name and
+// executes it once against sample data so unknown fields are reported at
+// startup.
+func parseButtonTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s template: %w", name, err)
+	}
+	sample := ButtonState{
+		Num: 1, DisplayNum: 1, Name: "1", State: "focused", Visible: true, Label: "1", Tooltip: "foot", WindowCount: 1, Command: "swaymsg",
+		OnClick: "swaymsg 'workspace 1'", OnMiddleClick: "", OnRightClick: "swaymsg 'move container to workspace 1'",
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("%s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
 type MonitorInfo struct {
 	Monitor string `json:"monitor"`
 	Output  string `json:"output"`
 }
 
-type Workspace struct {
-	Name    string `json:"name"`
-	Num     int    `json:"num"`
-	Focused bool   `json:"focused"`
-	Urgent  bool   `json:"urgent"`
-	Output  string `json:"output"`
+// Event is a single message from the i3/sway subscribe stream. Only the
+// fields needed to classify the event are decoded.
+type Event struct {
+	Change    string          `json:"change"`
+	Current   json.RawMessage `json:"current,omitempty"`
+	Container json.RawMessage `json:"container,omitempty"`
+	// PangoMarkup is only present on mode events.
+	PangoMarkup *bool `json:"pango_markup,omitempty"`
+
+	// kind is set by backends whose events are already labelled.
+	kind string
+	// lost is set by bufferEvents when events before this one were dropped.
+	lost bool
+}
+
+// Type infers which subscription the event belongs to from its payload,
+// since i3/sway do not label events on the subscribe stream.
+func (e Event) Type() string {
+	switch {
+	case e.kind != "":
+		return e.kind
+	case e.Container != nil:
+		return "window"
+	case e.PangoMarkup != nil:
+		return "mode"
+	case e.Current != nil:
+		return "workspace"
+	default:
+		return "output"
+	}
+}
+
+// parseEvent decodes a single line of the i3/sway subscribe stream.
+func parseEvent(line []byte) (Event, error) {
+	var ev Event
+	err := json.Unmarshal(line, &ev)
+	return ev, err
 }
 
 // waitForFile polls until the file at path is readable and non-empty, or context done.
+// A path that waiting will not fix, such as a directory or a file without
+// read permission, fails at once.
 func waitForFile(ctx context.Context, path string, interval time.Duration) ([]byte, error) {
+	if data, err := readIfExists(path); err != nil || len(data) > 0 {
+		return data, err
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			if _, err := os.Lstat(path); err == nil {
+				return nil, fmt.Errorf("timeout waiting for file %s, a symlink to a missing file: %w", path, ctx.Err())
+			}
 			return nil, fmt.Errorf("timeout waiting for file %s: %w", path, ctx.Err())
 		case <-ticker.C:
-			data, err := os.ReadFile(path)
-			if err == nil && len(data) > 0 {
-				return data, nil
+			data, err := readIfExists(path)
+			if err != nil || len(data) > 0 {
+				return data, err
 			}
 		}
 	}
 }
 
-// autoDetectMonitorOutput runs `swaymsg -t get_outputs` and returns the output
-// string for the first active monitor, formatted the same way as readMonitorOutput.
-func autoDetectMonitorOutput(ctx context.Context) (string, error) {
-	// Define only the fields we need from swaymsg JSON
-	type swayOutput struct {
-		Name   string `json:"name"`
-		Active bool   `json:"active"`
+// readIfExists reads the regular file at path, returning no data and no
+// error if it does not exist (yet).
+func readIfExists(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// removed since the stat, e.g. while being replaced
+		return nil, nil
+	}
+	return data, err
+}
 
-	cmd := exec.CommandContext(ctx, "swaymsg", "-t", "get_outputs")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to run swaymsg: %w", err)
+// autoDetectMonitorOutput queries `get_outputs` from sway, over $SWAYSOCK if
+// set and through swaymsg otherwise, and returns the focused output,
+// named the same way as in the monitors file.
+func autoDetectMonitorOutput(ctx context.Context) (string, error) {
+	sway := &i3Backend{cmd: "swaymsg", socket: os.Getenv("SWAYSOCK")}
+	outputs, err := sway.Outputs(ctx)
+	if err != nil {
+		return "", err
 	}
+	output, ok := focusedOutput(outputs)
+	if !ok {
+		return "", fmt.Errorf("no active monitor found")
+	}
+	return output, nil
+}
 
-	var outputs []swayOutput
-	if err := json.Unmarshal(out.Bytes(), &outputs); err != nil {
-		return "", fmt.Errorf("failed to parse sway outputs JSON: %w", err)
+// readMonitors reads the JSON array of monitor entries from file, polling
+// every interval while it is missing or partially written.
+func readMonitors(ctx context.Context, path string, interval time.Duration) ([]MonitorInfo, error) {
+	var raw json.RawMessage
+	if err := readJSONFile(ctx, path, interval, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", errMonitorsFile, err)
 	}
+	infos, err := parseMonitors(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errMonitorsFile, path, err)
+	}
+	return infos, nil
+}
 
-	for _, o := range outputs {
-		if o.Active {
-			return o.Name, nil
+// parseMonitors decodes the monitors file, reporting where it departs from
+// the expected array of {"monitor": ..., "output": ...} objects instead of
+// leaving lookups to fail with "monitor not found".
+func parseMonitors(raw json.RawMessage) ([]MonitorInfo, error) {
+	var entries []json.RawMessage
+	// null decodes to a nil slice rather than failing
+	if err := json.Unmarshal(raw, &entries); err != nil || entries == nil {
+		return nil, fmt.Errorf("want a JSON array of {\"monitor\", \"output\"} objects, got %s", jsonKind(raw))
+	}
+	infos := make([]MonitorInfo, 0, len(entries))
+	for i, entry := range entries {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(entry, &fields); err != nil {
+			return nil, fmt.Errorf("entry %d: want an object, got %s", i, jsonKind(entry))
+		}
+		var mi MonitorInfo
+		for _, field := range []struct {
+			name string
+			dst  *string
+		}{
+			{"monitor", &mi.Monitor},
+			{"output", &mi.Output},
+		} {
+			value, ok := fields[field.name]
+			if !ok {
+				return nil, fmt.Errorf("entry %d: missing %q", i, field.name)
+			}
+			if err := json.Unmarshal(value, field.dst); err != nil || *field.dst == "" {
+				return nil, fmt.Errorf("entry %d: %q must be a non-empty string, got %s", i, field.name, value)
+			}
 		}
+		infos = append(infos, mi)
 	}
+	return infos, nil
+}
 
-	return "", fmt.Errorf("no active monitor found")
+// jsonKind names the kind of JSON value in raw, for error messages.
+func jsonKind(raw json.RawMessage) string {
+	switch b := bytes.TrimSpace(raw); {
+	case len(b) == 0:
+		return "nothing"
+	case b[0] == '{':
+		return "an object"
+	case b[0] == '[':
+		return "an array"
+	case b[0] == '"':
+		return "a string"
+	case bytes.Equal(b, []byte("null")):
+		return "null"
+	case bytes.Equal(b, []byte("true")), bytes.Equal(b, []byte("false")):
+		return "a boolean"
+	default:
+		return "a number"
+	}
 }
 
-// readMonitorOutput reads JSON array from file and returns output for given monitor.
-func readMonitorOutput(ctx context.Context, path, monitor string) (string, error) {
-	data, err := waitForFile(ctx, path, 200*time.Millisecond)
+// readJSONFile unmarshals the JSON file at path into v, polling every
+// interval while it is missing or partially written by another process.
+func readJSONFile(ctx context.Context, path string, interval time.Duration, v any) error {
+	data, err := waitForFile(ctx, path, interval)
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	var infos []MonitorInfo
 	for {
-		if err := json.Unmarshal(data, &infos); err == nil {
-			break
+		err := json.Unmarshal(data, v)
+		if err == nil {
+			return nil
+		}
+		// Only input that ends early looks like a write still in progress;
+		// anything else will not fix itself by waiting.
+		if !truncatedJSON(err, data) {
+			return fmt.Errorf("invalid JSON in %s: %w", path, err)
 		}
 		select {
 		case <-ctx.Done():
-			return "", fmt.Errorf("parsing JSON %s: %w", path, ctx.Err())
-		case <-time.After(200 * time.Millisecond):
+			return fmt.Errorf("parsing JSON %s: %w (last error: %v)", path, ctx.Err(), err)
+		case <-time.After(interval):
 			data, _ = os.ReadFile(path)
 		}
 	}
+}
+
+// truncatedJSON reports whether err says data ended mid-value, as happens
+// when reading a file that is only partially written.
+func truncatedJSON(err error, data []byte) bool {
+	var synErr *json.SyntaxError
+	if !errors.As(err, &synErr) {
+		return false
+	}
+	return synErr.Offset >= int64(len(data)) && strings.Contains(synErr.Error(), "unexpected end")
+}
 
+// readMonitorMap reads the monitors file once and maps each monitor name to
+// its output. The first entry for a monitor wins.
+func readMonitorMap(ctx context.Context, path string, interval time.Duration) (map[string]string, error) {
+	infos, err := readMonitors(ctx, path, interval)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]string, len(infos))
 	for _, mi := range infos {
-		if mi.Monitor == monitor {
-			return mi.Output, nil
+		if _, ok := outputs[mi.Monitor]; !ok {
+			outputs[mi.Monitor] = mi.Output
 		}
 	}
-	return "", fmt.Errorf("monitor %q not found in %s", monitor, path)
+	return outputs, nil
+}
+
+// lookupMonitor returns the output of the first entry named monitor or, if
+// there is none and monitor is a number, of the entry at that index. A
+// monitor named "0" thus wins over the first entry.
+func lookupMonitor(infos []MonitorInfo, monitor string) (string, bool) {
+	if i := slices.IndexFunc(infos, func(mi MonitorInfo) bool { return mi.Monitor == monitor }); i >= 0 {
+		return infos[i].Output, true
+	}
+	if i, err := strconv.Atoi(monitor); err == nil && i >= 0 && i < len(infos) {
+		return infos[i].Output, true
+	}
+	return "", false
 }
 
-// fetchWorkspaces retrieves workspaces using the detected command.
-func fetchWorkspaces(ctx context.Context, cmdName string) ([]Workspace, error) {
-	cmd := exec.CommandContext(ctx, cmdName, "-t", "get_workspaces")
-	out, err := cmd.Output()
+// resolveOutput returns the output name for the configured monitor, either
+// from the monitors file or, when no monitor is set, by autodetection
+// through sway. Watchers with a backend listing outputs resolve the focused
+// output through it instead.
+func resolveOutput(ctx context.Context, cfg config) (string, error) {
+	if cfg.autoMonitor() {
+		output, err := autoDetectMonitorOutput(ctx)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errNoMonitor, err)
+		}
+		return output, nil
+	}
+	infos, err := readMonitors(ctx, cfg.MonitorsFile, cfg.FilePollInterval)
 	if err != nil {
-		return nil, fmt.Errorf("%s get_workspaces: %w", cmdName, err)
+		return "", err
+	}
+	output, ok := lookupMonitor(infos, cfg.Monitor)
+	if !ok {
+		return "", fmt.Errorf("%w: %q in %s", errMonitorNotFound, cfg.Monitor, cfg.MonitorsFile)
+	}
+	return cfg.canonicalOutput(output), nil
+}
+
+// snapshot is the compositor state a render is computed from.
+type snapshot struct {
+	Workspaces []Workspace
+	// Scratchpad is the number of windows in the scratchpad, only fetched
+	// with ShowScratchpad.
+	Scratchpad int
+	// Assignments maps workspace names to their configured output, only
+	// fetched with RespectAssignments.
+	Assignments map[string]string
+	// Windows maps workspace names to the titles of their windows, only
+	// fetched with Tooltips or ShowCounts.
+	Windows map[string][]string
+	// Mode is the current binding mode, tracked from events with ShowMode.
+	Mode string
+	// Fullscreen holds the names of the workspaces with a fullscreen
+	// window, only fetched with ShowFullscreen.
+	Fullscreen map[string]bool
+	// UrgentPhase is the class alternated on urgent buttons with
+	// UrgentFlash, empty otherwise.
+	UrgentPhase string
+	// FocusedApps maps the name of the workspace with the focused window
+	// to its app ID, only fetched with AppIcons.
+	FocusedApps map[string]string
+}
+
+// fetchSnapshot retrieves the workspaces, unless cached is non-nil, and any
+// extra state the configuration asks for.
+func fetchSnapshot(ctx context.Context, be Backend, cfg config, cached []Workspace) (snapshot, error) {
+	wss := cached
+	if wss == nil {
+		var err error
+		if wss, err = fetchWorkspaces(ctx, be, cfg); err != nil {
+			return snapshot{}, err
+		}
+		for i := range wss {
+			wss[i].Output = cfg.canonicalOutput(wss[i].Output)
+		}
+	}
+	snap := snapshot{Workspaces: wss}
+	if tb, ok := be.(treeBackend); ok && cfg.needsTree() {
+		root, err := tb.Tree(ctx)
+		if err != nil {
+			return snapshot{}, err
+		}
+		snap.Scratchpad = scratchpadCount(root)
+		if cfg.Tooltips || cfg.ShowCounts {
+			snap.Windows = workspaceWindows(root)
+		}
+		if cfg.ShowFullscreen {
+			snap.Fullscreen = fullscreenWorkspaces(root)
+		}
+		if len(cfg.AppIcons) > 0 {
+			snap.FocusedApps = make(map[string]string)
+			if ws, app, ok := focusedApp(root); ok {
+				snap.FocusedApps[ws] = app
+			}
+		}
 	}
-	var wss []Workspace
-	if err := json.Unmarshal(out, &wss); err != nil {
-		return nil, fmt.Errorf("unmarshal workspaces JSON: %w", err)
+	if ab, ok := be.(assignmentBackend); ok && cfg.RespectAssignments {
+		assigned, err := ab.Assignments(ctx)
+		if err != nil {
+			return snapshot{}, err
+		}
+		for name, output := range assigned {
+			assigned[name] = cfg.canonicalOutput(output)
+		}
+		snap.Assignments = assigned
 	}
-	return wss, nil
+	return snap, nil
 }
 
-// render builds and prints the EWW widget for the given output.
-func render(cmdName, output string) error {
-	states := make([]string, endWS+1)
-	visible := make([]bool, endWS+1)
-	for i := startWS; i <= endWS; i++ {
-		states[i] = "unoccupied"
-		visible[i] = true
+// fetchWorkspaces returns the workspaces, retrying up to cfg.FetchRetries
+// times with a doubling delay, e.g. while the compositor reloads. A missing
+// compositor CLI is not retried, and an empty reply is retried at least once.
+// No workspaces at all is not an error; every button is then unoccupied.
+func fetchWorkspaces(ctx context.Context, be Backend, cfg config) ([]Workspace, error) {
+	delay := cfg.FetchRetryDelay
+	for attempt := 1; ; attempt++ {
+		wss, err := be.Workspaces(ctx)
+		if err == nil {
+			return wss, nil
+		}
+		retries := cfg.FetchRetries
+		if errors.Is(err, errEmptyReply) {
+			retries = max(retries, 1)
+		}
+		if errors.Is(err, exec.ErrNotFound) || attempt > retries {
+			if attempt > 1 {
+				return nil, fmt.Errorf("fetching workspaces failed %d times: %w", attempt, err)
+			}
+			return nil, err
+		}
+		slog.Debug("fetching workspaces failed, retrying", "err", err, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("fetching workspaces: %w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
-	defer cancel()
-	wss, err := fetchWorkspaces(ctx, cmdName)
+// render builds the EWW widget for the given output from snap, writes it to
+// out and returns it.
+func render(out io.Writer, snap snapshot, be Backend, output string, cfg config) (string, error) {
+	widget, err := buildWidget(snap, be, cfg.Monitor, output, cfg)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if err := writeLine(out, widget); err != nil {
+		return "", err
+	}
+	return widget, nil
+}
+
+// writeLine writes line and its newline to out in a single Write, so a
+// reader of the stream never sees part of a widget.
+func writeLine(out io.Writer, line string) error {
+	buf := make([]byte, 0, len(line)+1)
+	buf = append(buf, line...)
+	buf = append(buf, '\n')
+	_, err := out.Write(buf)
+	return err
+}
+
+// renderAll builds the widget for every monitor from snap, writes them to out
+// as a single JSON object keyed by monitor name and returns that object.
+func renderAll(out io.Writer, snap snapshot, be Backend, monitors map[string]string, cfg config) (string, error) {
+	all, err := buildAll(snap, be, monitors, cfg)
+	if err != nil {
+		return "", err
+	}
+	if err := writeLine(out, all); err != nil {
+		return "", err
+	}
+	return all, nil
+}
+
+// buildAll returns the widgets for every monitor as a single JSON object
+// keyed by monitor name.
+func buildAll(snap snapshot, be Backend, monitors map[string]string, cfg config) (string, error) {
+	widgets := make(map[string]any, len(monitors))
+	for monitor, output := range monitors {
+		widget, err := buildWidget(snap, be, monitor, output, cfg)
+		if err != nil {
+			return "", err
+		}
+		if cfg.Format == "json" {
+			widgets[monitor] = json.RawMessage(widget)
+		} else {
+			widgets[monitor] = widget
+		}
+	}
+	b, err := json.Marshal(widgets)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// buildWidget returns the widget for output in the configured format.
+// monitor is the name it was configured by, for --json-include-meta.
+func buildWidget(snap snapshot, be Backend, monitor, output string, cfg config) (string, error) {
+	btns := workspaces.Compute(snap.Workspaces, output, cfg.buttonOptions(snap))
+	for i := range btns {
+		if snap.UrgentPhase != "" && strings.Contains(btns[i].State, "urgent") {
+			btns[i].State += " " + snap.UrgentPhase
+		}
+	}
+	var buf bytes.Buffer
+	for i := range btns {
+		buf.Reset()
+		if err := cfg.LabelTemplate.Execute(&buf, btns[i]); err != nil {
+			return "", fmt.Errorf("label template: %w", err)
+		}
+		btns[i].Label = buf.String()
+	}
+	if cfg.ShowScratchpad {
+		btns = append(btns, ButtonState{
+			Num:     -1,
+			Name:    workspaces.ScratchpadName,
+			State:   "scratchpad",
+			Visible: snap.Scratchpad > 0,
+			Label:   strconv.Itoa(snap.Scratchpad),
+		})
+	}
+	if cfg.ShowMode {
+		btns = append(btns, ButtonState{
+			Num:     -1,
+			Name:    snap.Mode,
+			State:   "mode",
+			Visible: snap.Mode != "" && snap.Mode != "default",
+			Label:   snap.Mode,
+		})
+	}
+	if cfg.Format == "json" {
+		if cfg.JSONIncludeMeta {
+			return formatJSONMeta(btns, monitor, output)
+		}
+		return formatJSON(btns)
+	}
+	onOutput := func(ws Workspace) bool { return ws.Focused && ws.Output == output }
+	if cfg.ActiveOutputClass != "" && slices.ContainsFunc(snap.Workspaces, onOutput) {
+		cfg.BoxClass += " " + cfg.ActiveOutputClass
+	}
+	return formatEww(btns, be, output, cfg)
+}
+
+// formatJSON returns the buttons as a JSON array.
+func formatJSON(btns []ButtonState) (string, error) {
+	if btns == nil {
+		btns = []ButtonState{}
+	}
+	out, err := json.Marshal(btns)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// jsonWidget is the JSON format with --json-include-meta, naming the monitor
+// and output the buttons are for.
+type jsonWidget struct {
+	// Monitor is empty when the output is detected.
+	Monitor string        `json:"monitor"`
+	Output  string        `json:"output"`
+	Buttons []ButtonState `json:"buttons"`
+}
+
+// formatJSONMeta returns the buttons in a JSON object along with the monitor
+// and output they are for.
+func formatJSONMeta(btns []ButtonState, monitor, output string) (string, error) {
+	if btns == nil {
+		btns = []ButtonState{}
+	}
+	if monitor == "auto" {
+		monitor = ""
+	}
+	out, err := json.Marshal(jsonWidget{Monitor: monitor, Output: output, Buttons: btns})
+	if err != nil {
+		return "", err
 	}
+	return string(out), nil
+}
 
-	for _, ws := range wss {
-		if ws.Output != output {
+// formatEww returns the EWW box S-expression for the buttons on output.
+func formatEww(btns []ButtonState, be Backend, output string, cfg config) (string, error) {
+	parts := make([]string, 0, len(btns)+2)
+	if cfg.PrefixWidget != "" {
+		parts = append(parts, cfg.PrefixWidget)
+	}
+	var buf bytes.Buffer
+	wsButtons := 0
+	for _, btn := range btns {
+		if btn.State == "scratchpad" {
+			parts = append(parts, fmt.Sprintf(scratchFormat, ewwEscape(be.Command("scratchpad", "")), btn.Visible, btn.Label))
 			continue
 		}
-		switch {
-		case ws.Urgent:
-			states[ws.Num] = "urgent"
-		case ws.Focused:
-			states[ws.Num] = "focused"
-		default:
-			states[ws.Num] = "occupied"
+		if btn.State == "mode" {
+			parts = append(parts, fmt.Sprintf(modeFormat, btn.Visible, ewwEscape(btn.Label)))
+			continue
+		}
+		if cfg.Separator != "" && wsButtons > 0 && wsButtons%cfg.GroupSize == 0 {
+			parts = append(parts, cfg.Separator)
+		}
+		wsButtons++
+		buf.Reset()
+		btn.State = stateClass(btn.State, cfg)
+		if cfg.ClassByNum && btn.Num >= 0 {
+			// after the state class, which stays first
+			btn.State += " ws-" + strconv.Itoa(btn.Num)
+		}
+		btn.Command = ewwEscape(be.Command("", ""))
+		btn.Tooltip = ewwEscape(btn.Tooltip)
+		btn.Label = ewwEscape(btn.Label)
+		target := strconv.Itoa(btn.Num)
+		if cfg.UseNames {
+			target = btn.Name
 		}
-		visible[ws.Num] = true
+		onClick := actionCommand(cfg.LeftClick, be, target)
+		if cfg.OnClickCmd != "" {
+			onClick = strings.ReplaceAll(cfg.OnClickCmd, "%d", strconv.Itoa(btn.Num))
+		}
+		btn.OnClick = clickCommand(onClick, cfg)
+		btn.OnMiddleClick = clickCommand(actionCommand(cfg.MiddleClick, be, target), cfg)
+		btn.OnRightClick = clickCommand(actionCommand(cfg.RightClick, be, target), cfg)
+		if err := cfg.ButtonTemplate.Execute(&buf, btn); err != nil {
+			return "", fmt.Errorf("button template: %w", err)
+		}
+		parts = append(parts, buf.String())
+	}
+	if cfg.SuffixWidget != "" {
+		parts = append(parts, cfg.SuffixWidget)
+	}
+	valign := ""
+	if cfg.Valign != "" {
+		valign = fmt.Sprintf(` :valign "%s"`, cfg.Valign)
 	}
+	widget := fmt.Sprintf(ewwFormat, cfg.BoxClass, cfg.Orientation, cfg.Halign, valign, cfg.Spacing, cfg.SpaceEvenly, strings.Join(parts, " "))
+	cmd := scrollCommand(be, output)
+	if cfg.ScrollWrap && cmd != "" {
+		cmd = gotoCommand(output, cfg)
+	}
+	if (cfg.ScrollSwitch || cfg.ScrollWrap) && cmd != "" {
+		// only eventbox supports :onscroll, so wrap the box in one
+		widget = fmt.Sprintf(scrollFormat, ewwEscape(cmd), widget)
+	}
+	return widget, nil
+}
 
-	parts := make([]string, 0, endWS)
-	for i := startWS; i <= endWS; i++ {
-		parts = append(parts, fmt.Sprintf(btnFormat, detectCommand(), i, visible[i], states[i], i))
+// stateClass returns the CSS classes for a button state such as "focused
+// fullscreen", renamed according to cfg.StateClasses.
+func stateClass(state string, cfg config) string {
+	if len(cfg.StateClasses) == 0 {
+		return state
 	}
-	widget := fmt.Sprintf(ewwFormat, strings.Join(parts, " "))
-	fmt.Println(widget)
-	return nil
+	classes := strings.Fields(state)
+	for i, class := range classes {
+		if to, ok := cfg.StateClasses[class]; ok {
+			classes[i] = to
+		}
+	}
+	return strings.Join(classes, " ")
 }
 
-// subscribeAndRender handles initial render and i3/sway subscriptions.
-func subscribeAndRender(monitor, file string) error {
-	cmdName := detectCommand()
+// clickCommand returns cmd escaped for an EWW click handler, detached from
+// EWW with OnClickAsync so slow commands do not block the bar.
+func clickCommand(cmd string, cfg config) string {
+	if cfg.OnClickAsync && cmd != "" {
+		// EWW runs handlers with sh -c; a subshell keeps any ; or && in
+		// cmd together, and the redirect lets EWW stop reading at once
+		cmd = "(" + cmd + ") >/dev/null 2>&1 &"
+	}
+	return ewwEscape(cmd)
+}
 
-	// initial render
-	execCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// ewwEscaper escapes a command for use inside a double-quoted EWW string.
+var ewwEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
 
-	var output string
-	var err error
-	if monitor == "" {
-		output, err = autoDetectMonitorOutput(execCtx)
+// ewwEscape escapes s for use inside a double-quoted EWW string, so
+// commands may contain quoted paths.
+func ewwEscape(s string) string {
+	return ewwEscaper.Replace(s)
+}
+
+// scrollCommand returns the :onscroll handler cycling the workspaces on
+// output, or "" if the backend cannot cycle them. EWW substitutes {} with
+// the scroll direction.
+func scrollCommand(be Backend, output string) string {
+	prev, next := be.Command("prev", output), be.Command("next", output)
+	if prev == "" || next == "" {
+		return ""
+	}
+	return fmt.Sprintf("[ {} = up ] && %s || %s", prev, next)
+}
+
+// renderOnce resolves the output and renders a single snapshot without
+// subscribing to events.
+func renderOnce(ctx context.Context, cfg config) error {
+	be, err := detectBackend(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	out, err := newSink(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	w := &watcher{cfg: cfg, be: be, out: out}
+	if err := w.refresh(ctx); err != nil {
+		return err
+	}
+	w.logStartup()
+	w.fetchMode(ctx)
+	return w.render(ctx)
+}
+
+// startWatcher detects the compositor, resolves the output, performs the
+// initial render unless cfg.NoInitialRender is set and starts watching the
+// monitors file if one is used.
+// Configurations received on reconfigured replace cfg, and stop is called
+// once nobody reads the widgets any more.
+func startWatcher(ctx context.Context, stop context.CancelFunc, cfg config, reconfigured <-chan config) (*watcher, error) {
+	be, err := detectBackend(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newSink(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{cfg: cfg, be: be, out: out, reconfigured: reconfigured, stop: stop}
+	w.metrics.dumpOnSignal(ctx)
+	if err := w.refresh(ctx); err != nil {
+		return nil, err
+	}
+	w.logStartup()
+	w.fetchMode(ctx)
+	if cfg.NoInitialRender {
+		slog.Debug("skipping the initial render, waiting for the first event")
+	} else if err := w.render(ctx); err != nil {
+		slog.Error("initial render failed", "err", err)
+	}
+	if cfg.usesMonitorsFile() {
+		w.fileChanged = watchFile(ctx, cfg.MonitorsFile, cfg.FilePollInterval)
+	}
+	return w, nil
+}
+
+// pollAndRender renders on a fixed interval instead of subscribing, for
+// environments where the subscribe IPC is unavailable.
+func pollAndRender(ctx context.Context, cfg config, reconfigured <-chan config) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	w, err := startWatcher(ctx, stop, cfg, reconfigured)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.fileChanged:
+			w.reload(ctx)
+		case cfg := <-w.reconfigured:
+			w.reconfigure(ctx, cfg)
+		case <-ticker.C:
+			if w.stale && !w.reload(ctx) {
+				continue
+			}
+			w.workspaces = nil
+			w.fetchMode(ctx)
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+		case <-w.flashC():
+			w.flashOff = !w.flashOff
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+		}
+	}
+}
+
+// validClickAction reports whether action is a known click action.
+func validClickAction(action string) bool {
+	return action == "switch" || action == "move" || action == "none"
+}
+
+// actionCommand returns the command line running action on the target
+// workspace, or "" for "none".
+func actionCommand(action string, be Backend, target string) string {
+	if action == "none" {
+		return ""
+	}
+	return be.Command(action, target)
+}
+
+// subscribeAndRender handles initial render and i3/sway subscriptions,
+// reconnecting when the subscription ends. It returns nil once ctx is
+// cancelled and the subscription has been torn down.
+func subscribeAndRender(ctx context.Context, cfg config, reconfigured <-chan config) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	w, err := startWatcher(ctx, stop, cfg, reconfigured)
+	if err != nil {
+		return err
+	}
+
+	backoff := minReconnectDelay
+	for reconnects := 0; ; reconnects++ {
+		started := time.Now()
+		err := w.watch(ctx)
+		for errors.Is(err, errResubscribe) && ctx.Err() == nil {
+			// the backend is created with the event types to subscribe to
+			slog.Info("subscribing to the reloaded event types", "events", w.cfg.subscribedEvents())
+			if be, err := detectBackend(ctx, w.cfg); err != nil {
+				slog.Warn("compositor not found, keeping the previous subscription", "err", err)
+			} else {
+				w.be = be
+			}
+			err = w.watch(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errSubscribeRejected) {
+			return err
+		}
+		if err == nil {
+			err = errors.New("subscription closed")
+		}
+		if time.Since(started) >= healthySubscription {
+			// the subscription worked for a while, so this is a fresh
+			// disconnect rather than one more failed attempt
+			reconnects, backoff = 0, minReconnectDelay
+		}
+		if reconnects == 0 && time.Since(started) < time.Second {
+			slog.Warn("subscribe exited immediately; if the compositor does not support subscribe, try --poll", "err", err)
+		}
+		if w.cfg.MaxReconnect > 0 && reconnects >= w.cfg.MaxReconnect {
+			return fmt.Errorf("giving up after %d reconnects: %w", reconnects, err)
+		}
+		slog.Warn("subscription ended, reconnecting", "err", err, "delay", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectDelay)
+		w.metrics.reconnects.Add(1)
+
+		// the compositor may have been replaced, so detect it again and
+		// catch up on anything missed while disconnected
+		be, err := detectBackend(ctx, w.cfg)
+		if err != nil {
+			slog.Warn("compositor not found, retrying with the previous one", "err", err)
+		} else {
+			w.be = be
+		}
+		if err := w.render(ctx); err != nil {
+			slog.Error("render failed", "err", err)
+		}
+	}
+}
+
+// watcher holds the state carried across subscription reconnects.
+type watcher struct {
+	cfg config
+	be  Backend
+	// out receives the rendered widgets.
+	out io.Writer
+	// output is the resolved output in single-monitor mode.
+	output string
+	// monitors maps every monitor to its output in all-monitors mode. It is
+	// only re-read when the monitors file or the outputs change, not per
+	// event.
+	monitors map[string]string
+	// stale is set when refreshing the output failed and should be retried.
+	stale bool
+	// fileChanged signals writes to the monitors file; nil when unused.
+	fileChanged <-chan struct{}
+	// workspaces is the last known workspace list, kept current from event
+	// payloads where the backend allows; nil forces a fetch on render.
+	workspaces []Workspace
+	// reconfigured delivers reloaded configurations.
+	reconfigured <-chan config
+	// last is the widget last written, which is not written again.
+	last string
+	// metrics are dumped on SIGUSR1.
+	metrics metrics
+	// stop ends watching, nil when rendering once.
+	stop context.CancelFunc
+	// flash ticks while a workspace is urgent with cfg.UrgentFlash, and
+	// flashOff alternates on every tick.
+	flash    *time.Ticker
+	flashOff bool
+	// mode is the current binding mode with ShowMode.
+	mode string
+}
+
+// watch opens a single event subscription and renders on relevant events,
+// coalescing bursts within cfg.Debounce, until the subscription ends, ctx
+// is cancelled or a reload changes the event types, returning
+// errResubscribe.
+func (w *watcher) watch(ctx context.Context) error {
+	// tear the subscription down whenever watch returns, and wait until the
+	// stream is closed so the subscribe process has been reaped
+	subCtx, cancel := context.WithCancel(ctx)
+	evCh, err := w.be.Subscribe(subCtx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	// keep reading the subscription while rendering
+	evCh = bufferEvents(subCtx, evCh)
+	defer func() {
+		cancel()
+		for range evCh {
+		}
+	}()
+	// events may have been missed before subscribing
+	w.workspaces = nil
+	w.fetchMode(ctx)
+
+	// a full render every cfg.Refresh catches up on any missed event
+	var refresh <-chan time.Time
+	if w.cfg.Refresh > 0 {
+		ticker := time.NewTicker(w.cfg.Refresh)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	var timer *time.Timer
+	var pending <-chan time.Time
+	schedule := func() {
+		if w.cfg.Debounce <= 0 {
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+			return
+		}
+		// restart the quiet period on every relevant event
+		if timer == nil {
+			timer = time.NewTimer(w.cfg.Debounce)
+		} else {
+			timer.Reset(w.cfg.Debounce)
+		}
+		pending = timer.C
+	}
+loop:
+	for {
+		select {
+		case ev, ok := <-evCh:
+			if !ok {
+				break loop
+			}
+			if w.handle(ctx, ev) {
+				schedule()
+			}
+		case <-w.fileChanged:
+			if w.reload(ctx) {
+				schedule()
+			}
+		case cfg := <-w.reconfigured:
+			if w.reconfigure(ctx, cfg) {
+				err = errResubscribe
+				break loop
+			}
+		case <-pending:
+			pending = nil
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+		case <-refresh:
+			w.workspaces = nil
+			w.fetchMode(ctx)
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+		case <-w.flashC():
+			w.flashOff = !w.flashOff
+			if err := w.render(ctx); err != nil {
+				slog.Error("render failed", "err", err)
+			}
+		}
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	return err
+}
+
+// handle updates the watcher for ev and reports whether it warrants a render.
+func (w *watcher) handle(ctx context.Context, ev Event) bool {
+	t := ev.Type()
+	w.metrics.events.Add(1)
+	slog.Debug("event received", "event_type", t, "event_change", ev.Change)
+	if ev.lost {
+		// whatever the dropped events changed, start over from the
+		// compositor's state
+		slog.Warn("events dropped while busy, fetching the full state")
+		w.metrics.overflows.Add(1)
+		w.workspaces = nil
+		w.fetchMode(ctx)
+		w.reload(ctx)
+		return true
+	}
+	if t == "mode" {
+		w.mode = ev.Change
+	}
+	if !w.cfg.rendersOn(t, ev.Change) {
+		if t == "workspace" {
+			// keep the cache in step with the changes not rendered on
+			w.applyEvent(ev)
+		}
+		return false
+	}
+	// the output mapping can change when displays are re-plugged, so
+	// refresh it on output events or if the last refresh failed
+	if t == "output" || w.stale {
+		w.reload(ctx)
+	}
+	w.applyEvent(ev)
+	return true
+}
+
+// applyEvent updates the cached workspaces for a workspace event, dropping
+// them if the backend cannot apply it. Window events keep them with event
+// backends.
+func (w *watcher) applyEvent(ev Event) {
+	eb, ok := w.be.(eventBackend)
+	if ok && ev.Type() == "window" {
+		// these backends follow up window changes that affect workspaces
+		// with workspace events, so the cache stays valid and only the
+		// tree is fetched again
+		return
+	}
+	if !ok || ev.Type() != "workspace" || w.workspaces == nil {
+		w.workspaces = nil
+		return
+	}
+	if w.workspaces, ok = eb.Apply(w.workspaces, ev); !ok {
+		w.workspaces = nil
+	}
+}
+
+// logStartup logs a one-line summary of the resolved setup, for pasting into
+// bug reports, unless cfg.Quiet is set.
+func (w *watcher) logStartup() {
+	if w.cfg.Quiet {
+		return
+	}
+	attrs := []any{"compositor", w.be.Name(), "command", w.be.Command("", "")}
+	if w.cfg.AllMonitors {
+		attrs = append(attrs, "monitors", len(w.monitors))
 	} else {
-		output, err = readMonitorOutput(execCtx, file, monitor)
+		attrs = append(attrs, "monitor", cmp.Or(w.cfg.Monitor, "auto"), "output", w.output)
+	}
+	switch {
+	case w.cfg.UseNames:
+		attrs = append(attrs, "workspaces", "by name")
+	case w.cfg.DynamicRange:
+		attrs = append(attrs, "workspaces", "dynamic")
+	default:
+		attrs = append(attrs, "workspaces", fmt.Sprintf("%d..%d", w.cfg.StartWS, w.cfg.EndWS))
+	}
+	attrs = append(attrs, "format", w.cfg.Format, "sink", w.cfg.Sink)
+	if w.cfg.Sink == "eww-update" {
+		attrs = append(attrs, "eww_var", w.cfg.EwwVar)
+	}
+	slog.Info("started", attrs...)
+}
+
+// fetchMode queries the current binding mode with ShowMode, assuming the
+// default mode if the backend cannot tell.
+func (w *watcher) fetchMode(ctx context.Context) {
+	if !w.cfg.ShowMode {
+		return
+	}
+	w.mode = "default"
+	mb, ok := w.be.(modeBackend)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.FetchTimeout)
+	defer cancel()
+	mode, err := mb.Mode(ctx)
+	if err != nil {
+		slog.Warn("querying the binding mode failed", "err", err)
+		return
+	}
+	w.mode = mode
+}
+
+// reload refreshes the output(s), logging failures and marking the watcher
+// stale so the refresh is retried later. It reports whether it succeeded.
+func (w *watcher) reload(ctx context.Context) bool {
+	if err := w.refresh(ctx); err != nil {
+		slog.Error("refreshing output failed", "monitor", w.cfg.Monitor, "err", err)
+		w.stale = true
+		return false
+	}
+	w.stale = false
+	return true
+}
+
+// reconfigure switches to cfg, re-resolves the output(s) and re-renders,
+// keeping the subscription. Settings fixed at startup keep their value. It
+// reports whether cfg subscribes to other event types, which takes a new
+// subscription.
+func (w *watcher) reconfigure(ctx context.Context, cfg config) bool {
+	for _, fixed := range []struct {
+		name    string
+		changed bool
+	}{
+		{"monitors-file", cfg.MonitorsFile != w.cfg.MonitorsFile},
+		{"file-poll-interval", cfg.FilePollInterval != w.cfg.FilePollInterval},
+		{"poll-interval", cfg.PollInterval != w.cfg.PollInterval},
+		{"refresh", cfg.Refresh != w.cfg.Refresh},
+		{"river-status-cmd", cfg.RiverStatusCmd != w.cfg.RiverStatusCmd},
+		{"socket", cfg.Socket != w.cfg.Socket},
+		// the backend reading them is created once
+		{"workspaces-file", cfg.WorkspacesFile != w.cfg.WorkspacesFile},
+		{"workspaces-from", cfg.WorkspacesFrom != w.cfg.WorkspacesFrom},
+		{"fifo", !slices.Equal(cfg.Fifo, w.cfg.Fifo)},
+		// the fifo sink keeps writing to its pipe in the background
+		{"sink", cfg.Sink != w.cfg.Sink && (cfg.Sink == "fifo" || w.cfg.Sink == "fifo")},
+	} {
+		if fixed.changed {
+			slog.Warn("setting cannot change without a restart, ignored", "flag", fixed.name)
+		}
+	}
+	cfg.MonitorsFile = w.cfg.MonitorsFile
+	cfg.MonitorsFileSet = w.cfg.MonitorsFileSet
+	cfg.FilePollInterval = w.cfg.FilePollInterval
+	cfg.PollInterval = w.cfg.PollInterval
+	cfg.Refresh = w.cfg.Refresh
+	cfg.RiverStatusCmd = w.cfg.RiverStatusCmd
+	cfg.Socket = w.cfg.Socket
+	cfg.WorkspacesFile = w.cfg.WorkspacesFile
+	cfg.WorkspacesFrom = w.cfg.WorkspacesFrom
+	cfg.Fifo = w.cfg.Fifo
+	if cfg.Sink == "fifo" || w.cfg.Sink == "fifo" {
+		cfg.Sink = w.cfg.Sink
+	}
+
+	if cfg.Sink != "fifo" {
+		out, err := newSink(ctx, cfg)
+		if err != nil {
+			slog.Error("creating sink failed, keeping the previous one", "err", err)
+		} else {
+			w.out = out
+		}
+	}
+	resubscribe := !slices.Equal(cfg.subscribedEvents(), w.cfg.subscribedEvents())
+	w.cfg = cfg
+	w.last = ""
+	// output aliases apply to fetched workspaces, so fetch them again
+	w.workspaces = nil
+	if w.reload(ctx) {
+		if err := w.render(ctx); err != nil {
+			slog.Error("render failed", "err", err)
+		}
 	}
+	return resubscribe
+}
+
+// refresh re-resolves the output, or every monitor's output in
+// all-monitors mode, within cfg.InitialTimeout. The previous values are kept
+// on failure.
+func (w *watcher) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.InitialTimeout)
+	defer cancel()
+	err := w.resolve(ctx)
+	if errors.Is(err, errMonitorsFile) {
+		return explainTimeout(err, "monitors file was not ready", w.cfg.InitialTimeout, "initial-timeout")
+	}
+	return explainTimeout(err, "compositor did not report its outputs", w.cfg.InitialTimeout, "initial-timeout")
+}
+
+// resolve re-resolves the output(s) for refresh.
+func (w *watcher) resolve(ctx context.Context) error {
+	if w.cfg.feed() && !w.cfg.AllMonitors && !w.cfg.autoMonitor() {
+		// fed workspaces name their outputs directly, and the monitor may
+		// have none of them yet
+		w.output = w.cfg.canonicalOutput(w.cfg.Monitor)
+		slog.Debug("resolved output", "monitor", w.cfg.Monitor, "output", w.output)
+		return nil
+	}
+	if w.cfg.OutputsFromWM || w.cfg.feed() {
+		return w.refreshFromWM(ctx)
+	}
+	if w.cfg.Monitor == "" && w.cfg.MonitorsFileSet && !w.cfg.AllMonitors {
+		// a monitors file given without a monitor to look up in it is a
+		// mistake rather than a request for the focused output
+		return fmt.Errorf("%w: --monitors-file %s needs --monitor", errNoMonitor, w.cfg.MonitorsFile)
+	}
+	if w.cfg.autoMonitor() && !w.cfg.AllMonitors {
+		// with no monitor configured, take the focused output from the
+		// detected compositor, so a bare launch needs no monitors file
+		if _, ok := w.be.(outputBackend); ok {
+			return w.refreshFromWM(ctx)
+		}
+	}
+	if w.cfg.AllMonitors {
+		monitors, err := readMonitorMap(ctx, w.cfg.MonitorsFile, w.cfg.FilePollInterval)
+		if err != nil {
+			return err
+		}
+		for monitor, output := range monitors {
+			monitors[monitor] = w.cfg.canonicalOutput(output)
+		}
+		w.monitors = monitors
+		return nil
+	}
+	output, err := resolveOutput(ctx, w.cfg)
 	if err != nil {
 		return err
 	}
-	if err := render(cmdName, output); err != nil {
-		log.Println("initial render error:", err)
+	slog.Debug("resolved output", "monitor", w.cfg.Monitor, "output", output)
+	w.output = output
+	return nil
+}
+
+// refreshFromWM resolves the output(s) from the outputs the compositor
+// reports, treating monitor names as output names.
+func (w *watcher) refreshFromWM(ctx context.Context) error {
+	ob, ok := w.be.(outputBackend)
+	if !ok {
+		return fmt.Errorf("%s cannot list its outputs, use the monitors file", w.be.Name())
 	}
+	outputs, err := ob.Outputs(ctx)
+	if err != nil {
+		return err
+	}
+	if w.cfg.AllMonitors {
+		w.monitors = make(map[string]string, len(outputs))
+		for _, o := range outputs {
+			w.monitors[o.Name] = o.Name
+		}
+		return nil
+	}
+	if w.cfg.autoMonitor() {
+		output, ok := focusedOutput(outputs)
+		if !ok {
+			return fmt.Errorf("%w: %s reports no active outputs", errNoMonitor, w.be.Name())
+		}
+		w.output = output
+	} else {
+		// the monitor may be given by connector name, description or alias
+		want := w.cfg.canonicalOutput(w.cfg.Monitor)
+		i := slices.IndexFunc(outputs, func(o wmOutput) bool { return o.Name == want || o.Description == want })
+		if i < 0 {
+			return fmt.Errorf("%w: %q among the %s outputs", errMonitorNotFound, w.cfg.Monitor, w.be.Name())
+		}
+		w.output = outputs[i].Name
+	}
+	slog.Debug("resolved output", "monitor", w.cfg.Monitor, "output", w.output)
+	return nil
+}
+
+// render renders the widget for the watcher's current output(s). If
+// fetching the state fails, nothing is written, so the last widget stays up
+// rather than one built from partial state, and the next render fetches
+// everything afresh.
+func (w *watcher) render(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.FetchTimeout)
+	defer cancel()
+	snap, err := fetchSnapshot(ctx, w.be, w.cfg, w.workspaces)
+	if err != nil {
+		w.metrics.fetchErrors.Add(1)
+		// the cache may hold events, e.g. an urgency being cleared, that
+		// were never rendered; the compositor has the settled state
+		w.workspaces = nil
+		return explainTimeout(err, "compositor did not respond", w.cfg.FetchTimeout, "fetch-timeout")
+	}
+	w.workspaces = snap.Workspaces
+	snap.Mode = w.mode
+	if w.cfg.UrgentFlash > 0 {
+		snap.UrgentPhase = "urgent-on"
+		if w.flashOff {
+			snap.UrgentPhase = "urgent-off"
+		}
+	}
+	w.updateFlash(slices.ContainsFunc(snap.Workspaces, func(ws Workspace) bool { return ws.Urgent && w.showsOutput(ws.Output) }))
 
-	// subscribe to events
-	subCmd := exec.Command(cmdName, "-t", "subscribe", "-m", `["window","workspace"]`)
-	stdout, err := subCmd.StdoutPipe()
+	var widget string
+	if w.cfg.AllMonitors {
+		widget, err = buildAll(snap, w.be, w.monitors, w.cfg)
+	} else {
+		widget, err = buildWidget(snap, w.be, w.cfg.Monitor, w.output, w.cfg)
+	}
 	if err != nil {
 		return err
 	}
-	if err := subCmd.Start(); err != nil {
+	if widget == w.last && !w.cfg.AlwaysRender {
+		w.metrics.unchanged.Add(1)
+		slog.Debug("widget unchanged, not written")
+		return nil
+	}
+	if err := writeLine(w.out, widget); err != nil {
+		if errors.Is(err, syscall.EPIPE) && w.stop != nil {
+			// EWW exited or is reloading and starts a new instance, so
+			// nobody will read further widgets
+			slog.Info("stdout closed, exiting", "err", err)
+			w.stop()
+			return nil
+		}
 		return err
 	}
+	w.metrics.renders.Add(1)
+	w.last = widget
+	if w.cfg.AllMonitors {
+		slog.Debug("rendered", "monitors", len(w.monitors))
+	} else {
+		slog.Debug("rendered", "output", w.output)
+	}
+	return nil
+}
 
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		if err := render(cmdName, output); err != nil {
-			log.Println("render error:", err)
+// showsOutput reports whether the widget shows the workspaces of output, so
+// urgency elsewhere does not flash it.
+func (w *watcher) showsOutput(output string) bool {
+	if !w.cfg.AllMonitors {
+		return output == w.output
+	}
+	for _, o := range w.monitors {
+		if o == output {
+			return true
 		}
 	}
-	if err := scanner.Err(); err != nil {
+	return false
+}
+
+// updateFlash starts the urgent flash ticker once a workspace is urgent and
+// stops it once none is, so there are no extra renders otherwise.
+func (w *watcher) updateFlash(urgent bool) {
+	on := urgent && w.cfg.UrgentFlash > 0
+	switch {
+	case on && w.flash == nil:
+		w.flash = time.NewTicker(w.cfg.UrgentFlash)
+	case !on && w.flash != nil:
+		w.flash.Stop()
+		w.flash = nil
+		w.flashOff = false
+	}
+}
+
+// flashC returns the channel of the urgent flash ticker, nil while it is
+// stopped.
+func (w *watcher) flashC() <-chan time.Time {
+	if w.flash == nil {
+		return nil
+	}
+	return w.flash.C
+}
+
+// options are the settings selecting what Run does, as opposed to the config
+// shaping the widgets.
+type options struct {
+	// command is one of commands.
+	command  string
+	poll     bool
+	logLevel slog.Level
+	// nav holds the flags of the goto command.
+	nav navigation
+	// printConfig prints settings instead of running command.
+	printConfig bool
+	configPath  string
+	settings    []setting
+}
+
+// setting is the resolved value of a flag and where it came from: "flag",
+// "file", "env", "orientation" for the vertical defaults, or "default".
+type setting struct {
+	name, value, source string
+}
+
+// printSettings writes settings in the config file format, so the output
+// can be used as a config file, with their sources as comments.
+func printSettings(out io.Writer, configPath string, settings []setting) error {
+	if _, err := fmt.Fprintf(out, "# config file: %s\n", configPath); err != nil {
 		return err
 	}
+	for _, s := range settings {
+		if _, err := fmt.Fprintf(out, "%s = %s # %s\n", s.name, strconv.Quote(s.value), s.source); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// detectCommand returns "swaymsg" if it successfully detects sway, otherwise "i3-msg".
-func detectCommand() string {
-	// first try swaymsg
-	if swayPath, err := exec.LookPath("swaymsg"); err == nil {
-		// verify it really is a sway instance
-		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
-		defer cancel()
-		if err := exec.CommandContext(ctx, swayPath, "-t", "get_version").Run(); err == nil {
-			return swayPath
+// commands lists the subcommands and what they do. A command line not
+// starting with one runs watch.
+var commands = []struct{ name, usage string }{
+	{"watch", "render on every workspace change (default)"},
+	{"render", "render a single snapshot and exit"},
+	{"goto", "switch to the next or previous workspace on an output, for scroll and click handlers"},
+	{"check", "check the compositor, monitors file, output and templates, report to stderr and exit"},
+	{"version", "print version and exit"},
+}
+
+// rawFlags holds the flag values converted into settings once parsed.
+type rawFlags struct {
+	persistent, onEvents, fifo    string
+	buttonTemplate, labelTemplate string
+	logLevel                      string
+}
+
+// configFlags defines on fs the settings shared by the commands, storing
+// them in cfg and raw.
+func configFlags(fs *flag.FlagSet, cfg *config, raw *rawFlags) {
+	fs.StringVar(&cfg.Monitor, "monitor", "", "monitor name, or index into the monitors file if no monitor has that name, to display workspaces for, or \"auto\" for the focused output; taken from this flag, then the config file, then $"+monitorEnv+", else auto")
+	fs.StringVar(&cfg.MonitorsFile, "monitors-file", defaultMonitorsFile(), "path to monitor JSON file")
+	fs.IntVar(&cfg.StartWS, "start-workspace", defaultStartWS, "first workspace number to display")
+	fs.IntVar(&cfg.EndWS, "end-workspace", defaultEndWS, "last workspace number to display")
+	fs.IntVar(&cfg.DisplayOffset, "display-offset", 0, "subtract this from the workspace numbers shown, e.g. 10 to label workspaces 11 to 15 as 1 to 5; clicks still target the real workspace")
+	fs.StringVar(&cfg.BoxClass, "box-class", "workspaces", "CSS class of the EWW box widget")
+	fs.StringVar(&cfg.ActiveOutputClass, "active-output-class", "", "CSS class added to the EWW box widget while its output has the focused workspace, e.g. active-output")
+	fs.StringVar(&cfg.Orientation, "orientation", "h", "orientation of the EWW box widget, h or v")
+	fs.StringVar(&cfg.Halign, "halign", "start", "horizontal alignment of the EWW box widget; center with --orientation v")
+	fs.StringVar(&cfg.Valign, "valign", "", "vertical alignment of the EWW box widget, unset by default; start with --orientation v")
+	fs.IntVar(&cfg.Spacing, "spacing", 6, "spacing between buttons in the EWW box widget")
+	fs.StringVar(&cfg.PrefixWidget, "prefix-widget", "", "EWW widget placed before the buttons, e.g. '(label :text \"ws\")'")
+	fs.StringVar(&cfg.SuffixWidget, "suffix-widget", "", "EWW widget placed after the buttons, e.g. a button running the goto command")
+	fs.IntVar(&cfg.GroupSize, "group-size", 0, "number of workspace buttons per group, separated by --separator")
+	fs.StringVar(&cfg.Separator, "separator", "", "EWW widget inserted between groups of --group-size buttons, e.g. '(label :class \"sep\" :text \"|\")'")
+	fs.BoolVar(&cfg.SpaceEvenly, "space-evenly", true, "distribute buttons evenly in the EWW box widget; false with --orientation v")
+	fs.StringVar(&raw.buttonTemplate, "button-template", btnTemplate, "Go template for each button; fields: .Num .DisplayNum .Name .State .Visible .Label .Tooltip .WindowCount .Command .OnClick .OnMiddleClick .OnRightClick")
+	fs.StringVar(&raw.labelTemplate, "label-template", "{{.Label}}", "Go template for the text of each workspace button, keeping the rest of the button template; fields: .Num .DisplayNum .Name .State .Label .WindowCount")
+	fs.BoolVar(&cfg.ScrollSwitch, "scroll-switch", false, "switch workspaces on this output by scrolling over the widget")
+	fs.BoolVar(&cfg.ScrollWrap, "scroll-wrap", false, "like --scroll-switch, but wrap around the workspace range by calling back into the goto command")
+	fs.StringVar(&cfg.OnClickCmd, "onclick-cmd", "", "command run on left click instead of the compositor command, with %d replaced by the workspace number")
+	fs.BoolVar(&cfg.OnClickAsync, "onclick-async", false, "run click commands in the background so slow ones do not block EWW")
+	fs.StringVar(&cfg.LeftClick, "left-click", "switch", "action on left click: switch, move or none")
+	fs.StringVar(&cfg.MiddleClick, "middle-click", "none", "action on middle click: switch, move or none")
+	fs.StringVar(&cfg.RightClick, "right-click", "none", "action on right click: switch, move or none")
+	fs.StringVar(&cfg.UrgentPriority, "urgent-priority", "urgent", "state of a focused urgent workspace: urgent, focused or combined (class focused-urgent)")
+	fs.StringVar(&cfg.Format, "format", "eww", "output format, eww or json")
+	fs.BoolVar(&cfg.JSONIncludeMeta, "json-include-meta", false, "with --format json, emit an object with the monitor, output and buttons instead of the buttons array")
+	cfg.AppIcons = pairFlag{}
+	fs.Var(pairFlag(cfg.AppIcons), "app-icons", "APP=ICON appending ICON to the label of the focused workspace while its focused window has app ID or class APP, at the cost of fetching the layout tree; repeatable")
+	cfg.StateClasses = pairFlag{}
+	fs.Var(pairFlag(cfg.StateClasses), "state-class", "STATE=CLASS renaming the CSS class emitted for a button state, e.g. focused=active,unoccupied=empty; repeatable")
+	cfg.OutputAliases = pairFlag{}
+	fs.Var(pairFlag(cfg.OutputAliases), "output-alias", "FROM=TO mapping output name FROM, e.g. from the monitors file, to the name the compositor reports; repeatable")
+	fs.BoolVar(&cfg.OutputsFromWM, "outputs-from-wm", false, "take outputs from the compositor instead of the monitors file, matching monitor names against output names")
+	fs.BoolVar(&cfg.AllMonitors, "all-monitors", false, "render every monitor in the monitors file as a JSON object keyed by monitor name")
+	fs.BoolVar(&cfg.ClassByNum, "class-by-num", false, "add the class ws-N to the button of workspace number N, after its state class")
+	fs.BoolVar(&cfg.ShowMode, "show-mode", false, "add a label showing the i3/sway binding mode, hidden in the default mode")
+	fs.BoolVar(&cfg.ShowFullscreen, "show-fullscreen", false, "add the class fullscreen to the focused workspace while it has a fullscreen window, at the cost of fetching the layout tree")
+	fs.BoolVar(&cfg.ShowCounts, "show-counts", false, "append the number of windows to each occupied workspace label, e.g. 3·2")
+	fs.BoolVar(&cfg.Tooltips, "tooltips", false, "add a tooltip listing the windows on each workspace, at the cost of fetching the layout tree")
+	fs.BoolVar(&cfg.ShowScratchpad, "show-scratchpad", false, "add a button showing the number of windows in the scratchpad")
+	fs.BoolVar(&cfg.RespectAssignments, "respect-assignments", false, "place empty workspaces according to the i3/sway workspace output assignments")
+	fs.StringVar(&cfg.Socket, "socket", "", "i3/sway IPC socket to use instead of $SWAYSOCK or $I3SOCK, also passed to the commands run")
+	fs.StringVar(&cfg.RiverStatusCmd, "river-status-cmd", "", "shell command printing river tag state as JSON lines, required under river")
+	fs.StringVar(&cfg.WorkspacesFile, "workspaces-file", "", "read the workspaces as a JSON array shaped like the i3/sway get_workspaces reply from this file instead of the compositor, rendering whenever it changes")
+	fs.StringVar(&cfg.WorkspacesFrom, "workspaces-from", "", "read the workspaces as a JSON array shaped like the i3/sway get_workspaces reply from - (stdin) instead of the compositor; implies render")
+	fs.BoolVar(&cfg.HideEmpty, "hide-empty", false, "hide unoccupied workspaces")
+	fs.StringVar(&raw.persistent, "persistent", "", "comma-separated workspace numbers or names that stay visible with --hide-empty and are always shown with --dynamic-range or --use-names")
+	fs.BoolVar(&cfg.Reverse, "reverse", false, "order buttons from the last workspace to the first")
+	fs.BoolVar(&cfg.DynamicRange, "dynamic-range", false, "show only existing, persistent and assigned workspaces instead of the fixed range")
+	fs.BoolVar(&cfg.UseNames, "use-names", false, "label buttons by workspace name and show only existing workspaces")
+	fs.DurationVar(&cfg.FilePollInterval, "file-poll-interval", 200*time.Millisecond, "interval for polling the monitors file while it is missing or being written")
+	fs.IntVar(&cfg.FetchRetries, "fetch-retries", 2, "number of times a failed workspace fetch is retried")
+	fs.DurationVar(&cfg.FetchRetryDelay, "fetch-retry-delay", 50*time.Millisecond, "delay before the first fetch retry, doubled for each further retry")
+	fs.DurationVar(&cfg.FetchTimeout, "fetch-timeout", 500*time.Millisecond, "timeout for querying the compositor state on each render")
+	fs.DurationVar(&cfg.InitialTimeout, "initial-timeout", 5*time.Second, "timeout for resolving the output at startup and when the monitors change")
+	fs.DurationVar(&cfg.DetectTimeout, "detect-timeout", 300*time.Millisecond, "timeout for probing each compositor during detection")
+	fs.StringVar(&raw.logLevel, "log-level", "info", "log verbosity: debug, info, warn or error")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "do not log the setup summary at startup")
+}
+
+// watchFlags defines on fs the settings only the watch command has, for
+// keeping the widget up to date, storing them in cfg, raw and opts.
+func watchFlags(fs *flag.FlagSet, cfg *config, raw *rawFlags, opts *options) {
+	fs.BoolVar(&cfg.NoInitialRender, "no-initial-render", false, "do not render at startup, e.g. to keep a value EWW already has; nothing renders until the first event (or --poll tick)")
+	fs.BoolVar(&cfg.AlwaysRender, "always-render", false, "write the widget on every render, even when it is unchanged")
+	fs.StringVar(&cfg.Sink, "sink", "stdout", "where widgets go: stdout for a deflisten, eww-update to set --eww-var, or fifo to write to the named pipe --fifo")
+	fs.StringVar(&cfg.EwwVar, "eww-var", "", "EWW variable set with --sink eww-update")
+	fs.StringVar(&raw.fifo, "fifo", "", "comma-separated named pipes written with --sink fifo, one per reader, created if missing; readers such as cat get the current widget when they connect")
+	fs.DurationVar(&cfg.Debounce, "debounce", 50*time.Millisecond, "coalesce events arriving within this window into one render, 0 to disable")
+	fs.DurationVar(&cfg.UrgentFlash, "urgent-flash", 0, "while a workspace is urgent, re-render at this interval alternating the class urgent-on and urgent-off on urgent buttons; 0 to disable")
+	fs.DurationVar(&cfg.Refresh, "refresh", 0, "also render from a fresh fetch at this interval while subscribed, as a safety net for missed events; 0 to disable")
+	fs.IntVar(&cfg.MaxReconnect, "max-reconnect", 0, "maximum number of subscription reconnects, 0 for unlimited")
+	fs.BoolVar(&opts.poll, "poll", false, "render on a fixed interval instead of subscribing to events")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 500*time.Millisecond, "render interval in --poll mode")
+	fs.StringVar(&raw.onEvents, "on-events", "", "comma-separated event types to subscribe to and render on, out of "+strings.Join(eventTypes, ", ")+", or workspace:CHANGE for single i3/sway workspace changes out of "+strings.Join(workspaceChanges, ", ")+"; by default workspace and output, window with tree state and mode with --show-mode")
+}
+
+// parseFlags parses the command line in args, merges in the config file and
+// returns the resulting configuration. It is run again to reload the
+// configuration on SIGHUP.
+func parseFlags(args []string, handling flag.ErrorHandling) (config, options, error) {
+	opts := options{command: "watch"}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		opts.command, args = args[0], args[1:]
+	}
+	if !slices.ContainsFunc(commands, func(c struct{ name, usage string }) bool { return c.name == opts.command }) {
+		return config{}, options{}, fmt.Errorf("unknown command %q, run %s -h for the list", opts.command, os.Args[0])
+	}
+
+	fs := flag.NewFlagSet(os.Args[0]+" "+opts.command, handling)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-8s %s\n", c.name, c.usage)
 		}
+		fmt.Fprintf(out, "\nFlags of %s:\n", opts.command)
+		fs.PrintDefaults()
+		if opts.command == "watch" {
+			fmt.Fprint(out, `
+Signals of watch:
+  SIGHUP   reload the config file and flags
+  SIGUSR1  dump event and render counters to stderr, in the Prometheus text
+           exposition format for a node exporter textfile collector
+  SIGUSR2  reset the counters
+`)
+		}
+	}
+	if opts.command == "version" {
+		if err := fs.Parse(args); err != nil {
+			return config{}, options{}, err
+		}
+		return config{}, opts, nil
+	}
+
+	// the commands were flags before, which watch still accepts
+	var legacyVersion, legacyOnce, legacyCheck bool
+	if opts.command == "watch" {
+		fs.BoolVar(&legacyVersion, "version", false, "same as the version command")
+		fs.BoolVar(&legacyVersion, "v", false, "same as the version command")
+		fs.BoolVar(&legacyOnce, "once", false, "same as the render command")
+		fs.BoolVar(&legacyCheck, "check", false, "same as the check command")
+	}
+	if opts.command == "goto" {
+		fs.StringVar(&opts.nav.direction, "direction", "next", "workspace to go to: next or prev, or the EWW scroll direction down or up")
+		fs.StringVar(&opts.nav.output, "output", "", "output whose workspaces to cycle, by default the one of --monitor")
+		fs.BoolVar(&opts.nav.wrap, "wrap", false, "go from the last workspace of the range to the first and back")
+		fs.BoolVar(&opts.nav.skipEmpty, "skip-empty", false, "go to the next or previous workspace that exists on the output, skipping empty numbers")
+	}
+	var cfg config
+	var raw rawFlags
+	configFlags(fs, &cfg, &raw)
+	// the other commands keep the watch settings at their defaults
+	watchSet := fs
+	if opts.command != "watch" {
+		watchSet = flag.NewFlagSet(opts.command, flag.ContinueOnError)
 	}
-	// fallback to i3-msg
-	if i3Path, err := exec.LookPath("i3-msg"); err == nil {
-		return i3Path
+	watchFlags(watchSet, &cfg, &raw, &opts)
+	configPath := fs.String("config", defaultConfigPath(), "path to config file; command-line flags override its values")
+	printConfig := fs.Bool("print-config", false, "print the resolved settings in config file format, each with where it came from, and exit")
+	if err := fs.Parse(args); err != nil {
+		return config{}, options{}, err
 	}
-	// last resort, just the name (will error later if not on PATH)
-	return "i3-msg"
+	sources := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = "flag" })
+
+	switch {
+	case legacyVersion:
+		return config{}, options{command: "version"}, nil
+	case legacyCheck:
+		opts.command = "check"
+	case legacyOnce:
+		opts.command = "render"
+	}
+
+	explicitConfig := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicitConfig = true
+		}
+	})
+	if err := loadConfigFile(fs, watchSet, *configPath, explicitConfig); err != nil {
+		return config{}, options{}, err
+	}
+	opts.printConfig = *printConfig
+	opts.configPath = *configPath
+	fs.Visit(func(f *flag.Flag) {
+		if sources[f.Name] == "" {
+			sources[f.Name] = "file"
+		}
+	})
+
+	if cfg.Orientation == "v" {
+		// stack the buttons at the top of a vertical bar, centred across
+		// it, unless the alignment was set on the command line or in the
+		// config file
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["halign"] {
+			cfg.Halign = "center"
+			sources["halign"] = "orientation"
+		}
+		if !set["valign"] {
+			cfg.Valign = "start"
+			sources["valign"] = "orientation"
+		}
+		if !set["space-evenly"] {
+			cfg.SpaceEvenly = false
+			sources["space-evenly"] = "orientation"
+		}
+	}
+
+	level, err := parseLogLevel(raw.logLevel)
+	if err != nil {
+		return config{}, options{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	opts.logLevel = level
+
+	if cfg.Monitor == "" && !cfg.AllMonitors {
+		if cfg.Monitor = os.Getenv(monitorEnv); cfg.Monitor != "" {
+			sources["monitor"] = "env"
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "print-config", "version", "v", "once", "check", "direction", "output", "wrap", "skip-empty":
+			// not settings, and not accepted in a config file
+			return
+		}
+		source := sources[f.Name]
+		if source == "" {
+			source = "default"
+		}
+		opts.settings = append(opts.settings, setting{f.Name, f.Value.String(), source})
+	})
+
+	if cfg.ButtonTemplate, err = parseButtonTemplate("button", raw.buttonTemplate); err != nil {
+		return config{}, options{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.LabelTemplate, err = parseButtonTemplate("label", raw.labelTemplate); err != nil {
+		return config{}, options{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg.MonitorsFileSet = sources["monitors-file"] != ""
+	cfg.Persistent = parseList(raw.persistent)
+	cfg.OnEvents = parseList(raw.onEvents)
+	cfg.Fifo = parseList(raw.fifo)
+	if err := cfg.validate(); err != nil {
+		return config{}, options{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	switch opts.nav.direction {
+	case "down":
+		opts.nav.direction = "next"
+	case "up":
+		opts.nav.direction = "prev"
+	case "", "next", "prev":
+	default:
+		return config{}, options{}, fmt.Errorf("invalid configuration: direction must be next, prev, down or up, got %q", opts.nav.direction)
+	}
+	// workspaces fed on stdin do not change, so there is nothing to
+	// subscribe to
+	if opts.command == "watch" && cfg.WorkspacesFrom != "" {
+		opts.command = "render"
+	}
+	if opts.command == "render" && cfg.Sink == "fifo" {
+		return config{}, options{}, errors.New("invalid configuration: sink fifo needs the watch command, render exits before a reader connects")
+	}
+	return cfg, opts, nil
 }
 
-// Run sets up and starts the subscription-render loop.
+// logLevel is the minimum level logged, adjustable on reload.
+var logLevel slog.LevelVar
+
+// fatalf logs an error and exits with exitError. The standard log package
+// logs at info level once slog is the default, so it would be hidden by
+// --log-level=error.
+func fatalf(format string, args ...any) {
+	exitf(exitError, format, args...)
+}
+
+// exitf logs an error and exits with code.
+func exitf(code int, format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(code)
+}
+
+// Run runs the command given on the command line, by default the
+// subscription-render loop.
 func Run(ctx context.Context) {
-	monitor := flag.String("monitor", "", "monitor name to display workspaces for, empty for autodetect")
-	file := flag.String("monitors-file", "/tmp/monitors.json", "path to monitor JSON file")
-	versionFlag := flag.Bool("version", false, "print version and exit")
-	versionFlagShort := flag.Bool("v", false, "print version and exit (shorthand)")
-	flag.Parse()
-
-	if *versionFlag || *versionFlagShort {
-		version.Print()
+	cfg, opts, err := parseFlags(os.Args[1:], flag.ContinueOnError)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		exitf(exitCode(fmt.Errorf("%w: %w", errUsage, err)), "error: %v", err)
+	}
+	if opts.command == "version" {
+		if err := version.Print(); err != nil {
+			fatalf("version: %v", err)
+		}
+		return
+	}
+	if opts.printConfig {
+		if err := printSettings(os.Stdout, opts.configPath, opts.settings); err != nil {
+			fatalf("print config: %v", err)
+		}
+		return
+	}
+
+	if cfg.Socket != "" {
+		// commands started from here, like the subscription, talk to the
+		// same instance
+		os.Setenv("SWAYSOCK", cfg.Socket)
+		os.Setenv("I3SOCK", cfg.Socket)
+	}
+
+	logLevel.Set(opts.logLevel)
+	// logs go to stderr so they never mix with the widgets on stdout
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})))
+
+	switch opts.command {
+	case "check":
+		if err := runCheck(ctx, cfg, os.Stderr); err != nil {
+			os.Exit(exitCode(err))
+		}
+		return
+	case "render":
+		if err := renderOnce(ctx, cfg); err != nil {
+			exitf(exitCode(err), "error: %v", err)
+		}
+		return
+	case "goto":
+		if err := runGoto(ctx, cfg, opts.nav); err != nil {
+			exitf(exitCode(err), "error: %v", err)
+		}
 		return
 	}
 
-	if err := subscribeAndRender(*monitor, *file); err != nil {
+	// cancel on SIGINT/SIGTERM so the subscribe process is cleaned up
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// writing to a closed stdout then fails with EPIPE, which the watcher
+	// handles, instead of killing the process; unlike ignoring SIGPIPE
+	// this is not inherited by the commands run
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+
+	run := subscribeAndRender
+	if opts.poll {
+		run = pollAndRender
+	}
+	if err := run(ctx, cfg, reloadOnHangup(ctx, opts)); err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			log.Fatalf("command exited with error: %v", err)
+			exitf(exitCode(err), "command exited with error: %v", err)
 		}
-		log.Fatalf("error: %v", err)
+		exitf(exitCode(err), "error: %v", err)
 	}
 }
+
+// reloadOnHangup re-reads the command line and config file on every SIGHUP
+// and sends the new configuration on the returned channel. Invalid
+// configurations are logged and skipped.
+func reloadOnHangup(ctx context.Context, opts options) <-chan config {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reconfigured := make(chan config)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			cfg, newOpts, err := parseFlags(os.Args[1:], flag.ContinueOnError)
+			if err != nil {
+				slog.Error("reloading configuration failed, keeping the current one", "err", err)
+				continue
+			}
+			if newOpts.poll != opts.poll {
+				slog.Warn("poll cannot change without a restart, ignored")
+			}
+			logLevel.Set(newOpts.logLevel)
+			slog.Info("configuration reloaded")
+			select {
+			case reconfigured <- cfg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return reconfigured
+}
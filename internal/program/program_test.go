@@ -0,0 +1,553 @@
+package program
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderWidget(t *testing.T) {
+	tests := []struct {
+		name string
+		wss  []Workspace
+		want string
+	}{
+		{
+			name: "all unoccupied",
+			want: `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" (button :onclick "swaymsg 'workspace 1'" :visible true :class "unoccupied" "1") (button :onclick "swaymsg 'workspace 2'" :visible true :class "unoccupied" "2") (button :onclick "swaymsg 'workspace 3'" :visible true :class "unoccupied" "3"))`,
+		},
+		{
+			name: "focused",
+			wss:  []Workspace{{Num: 2, Name: "2", Focused: true, Visible: true, Output: "DP-1"}},
+			want: `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" (button :onclick "swaymsg 'workspace 1'" :visible true :class "unoccupied" "1") (button :onclick "swaymsg 'workspace 2'" :visible true :class "focused" "2") (button :onclick "swaymsg 'workspace 3'" :visible true :class "unoccupied" "3"))`,
+		},
+		{
+			name: "urgent",
+			wss:  []Workspace{{Num: 3, Name: "3", Urgent: true, Output: "DP-1"}},
+			want: `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" (button :onclick "swaymsg 'workspace 1'" :visible true :class "unoccupied" "1") (button :onclick "swaymsg 'workspace 2'" :visible true :class "unoccupied" "2") (button :onclick "swaymsg 'workspace 3'" :visible true :class "urgent" "3"))`,
+		},
+		{
+			name: "urgent over focused",
+			wss:  []Workspace{{Num: 1, Name: "1", Focused: true, Visible: true, Urgent: true, Output: "DP-1"}},
+			want: `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" (button :onclick "swaymsg 'workspace 1'" :visible true :class "urgent" "1") (button :onclick "swaymsg 'workspace 2'" :visible true :class "unoccupied" "2") (button :onclick "swaymsg 'workspace 3'" :visible true :class "unoccupied" "3"))`,
+		},
+		{
+			name: "other output",
+			wss:  []Workspace{{Num: 2, Name: "2", Focused: true, Visible: true, Output: "HDMI-A-1"}},
+			want: `(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true" (button :onclick "swaymsg 'workspace 1'" :visible true :class "unoccupied" "1") (button :onclick "swaymsg 'workspace 2'" :visible true :class "unoccupied" "2") (button :onclick "swaymsg 'workspace 3'" :visible true :class "unoccupied" "3"))`,
+		},
+	}
+	cfg := testConfig(t, "-end-workspace", "3")
+	be := &swayBackend{i3Backend{cmd: "swaymsg"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			widget, err := render(&buf, snapshot{Workspaces: tt.wss}, be, "DP-1", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("render wrote\n%s\nwant\n%s", got, tt.want)
+			}
+			if widget+"\n" != buf.String() {
+				t.Errorf("render returned %q, wrote %q", widget, buf.String())
+			}
+		})
+	}
+}
+
+func TestRenderClassByNum(t *testing.T) {
+	cfg := testConfig(t, "-end-workspace", "2", "-class-by-num")
+	be := &swayBackend{i3Backend{cmd: "swaymsg"}}
+	wss := []Workspace{{Num: 2, Name: "2", Focused: true, Visible: true, Output: "DP-1"}}
+	var buf bytes.Buffer
+	if _, err := render(&buf, snapshot{Workspaces: wss}, be, "DP-1", cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`:class "unoccupied ws-1" "1"`, `:class "focused ws-2" "2"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("widget %s\nlacks %s", buf.String(), want)
+		}
+	}
+}
+
+func TestRenderVertical(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "vertical defaults",
+			args: []string{"-orientation", "v"},
+			want: `(box :class "workspaces" :orientation "v" :halign "center" :valign "start" :spacing "6" :space-evenly "false" `,
+		},
+		{
+			name: "overridden",
+			args: []string{"-orientation", "v", "-halign", "end", "-space-evenly"},
+			want: `(box :class "workspaces" :orientation "v" :halign "end" :valign "start" :spacing "6" :space-evenly "true" `,
+		},
+	}
+	be := &swayBackend{i3Backend{cmd: "swaymsg"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, append(tt.args, "-end-workspace", "1")...)
+			var buf bytes.Buffer
+			if _, err := render(&buf, snapshot{}, be, "DP-1", cfg); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want + `(button :onclick "swaymsg 'workspace 1'" :visible true :class "unoccupied" "1"))` + "\n"
+			if buf.String() != want {
+				t.Errorf("render wrote\n%s\nwant\n%s", buf.String(), want)
+			}
+		})
+	}
+}
+
+// widgetLine returns the default widget for buttons 1 onwards with the given
+// classes, as clicked through swaymsg.
+func widgetLine(classes ...string) string {
+	var b strings.Builder
+	b.WriteString(`(box :class "workspaces" :orientation "h" :halign "start" :spacing "6" :space-evenly "true"`)
+	for i, class := range classes {
+		fmt.Fprintf(&b, ` (button :onclick "swaymsg 'workspace %d'" :visible true :class "%s" "%d")`, i+1, class, i+1)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// captureStdout redirects os.Stdout for the duration of the test and returns
+// the lines written to it.
+func captureStdout(t *testing.T) <-chan string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() {
+		os.Stdout = orig
+		w.Close()
+	})
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+// nextLine returns the next line from lines, failing the test if none comes.
+func nextLine(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no widget written")
+		return ""
+	}
+}
+
+// watchFake runs subscribeAndRender against f with the given flags until the
+// test ends, returning the widgets written.
+func watchFake(t *testing.T, f *fakeCompositor, args ...string) <-chan string {
+	t.Helper()
+	lines := captureStdout(t)
+	cfg := testConfig(t, append([]string{"-end-workspace", "3", "-debounce", "0", "-quiet"}, args...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- subscribeAndRender(ctx, cfg, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("subscribeAndRender: %v", err)
+		}
+	})
+	return lines
+}
+
+func TestSubscribeAndRender(t *testing.T) {
+	f := newFakeCompositor(t, `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"}]`)
+	lines := watchFake(t, f)
+	if got, want := nextLine(t, lines), widgetLine("focused", "unoccupied", "unoccupied"); got != want {
+		t.Fatalf("initial widget\n%s\nwant\n%s", got, want)
+	}
+
+	f.setWorkspaces(`[{"num":1,"name":"1","output":"DP-1"},{"num":2,"name":"2","focused":true,"visible":true,"output":"DP-1"}]`)
+	f.events <- `{"change":"focus","current":{"num":2,"name":"2","focused":true,"output":"DP-1"}}`
+	if got, want := nextLine(t, lines), widgetLine("occupied", "focused", "unoccupied"); got != want {
+		t.Fatalf("widget after focus\n%s\nwant\n%s", got, want)
+	}
+
+	f.setWorkspaces(`[{"num":1,"name":"1","output":"DP-1"},{"num":2,"name":"2","focused":true,"visible":true,"output":"DP-1"},{"num":3,"name":"3","urgent":true,"output":"DP-1"}]`)
+	f.events <- `{"change":"urgent","current":{"num":3,"name":"3","urgent":true,"output":"DP-1"}}`
+	if got, want := nextLine(t, lines), widgetLine("occupied", "focused", "urgent"); got != want {
+		t.Fatalf("widget after urgent\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFetchNoWorkspaces(t *testing.T) {
+	t.Run("empty list", func(t *testing.T) {
+		newFakeCompositor(t, `[]`)
+		cfg := testConfig(t, "-end-workspace", "3", "-fetch-retries", "0")
+		w := &watcher{cfg: cfg, be: &swayBackend{i3Backend{cmd: "swaymsg"}}, output: "DP-1"}
+		var buf bytes.Buffer
+		w.out = &buf
+		if err := w.render(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), widgetLine("unoccupied", "unoccupied", "unoccupied")+"\n"; got != want {
+			t.Errorf("render wrote\n%s\nwant\n%s", got, want)
+		}
+	})
+	t.Run("empty reply", func(t *testing.T) {
+		f := newFakeCompositor(t, `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"}]`)
+		f.queue(" \n")
+		// an empty reply is retried even when retries are off
+		cfg := testConfig(t, "-fetch-retries", "0", "-fetch-retry-delay", "1ms")
+		wss, err := fetchWorkspaces(context.Background(), &swayBackend{i3Backend{cmd: "swaymsg"}}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wss) != 1 || f.fetches != 2 {
+			t.Errorf("got %d workspaces after %d fetches, want 1 after 2", len(wss), f.fetches)
+		}
+	})
+}
+
+func TestUrgencyClearedAfterFailedFetch(t *testing.T) {
+	f := newFakeCompositor(t, `[{"num":1,"name":"1","focused":true,"visible":true,"urgent":true,"output":"DP-1"}]`)
+	lines := watchFake(t, f, "-fetch-retry-delay", "1ms")
+	if got, want := nextLine(t, lines), widgetLine("urgent", "unoccupied", "unoccupied"); got != want {
+		t.Fatalf("initial widget\n%s\nwant\n%s", got, want)
+	}
+
+	// the event cannot be applied to the cache, so it takes a fetch, which
+	// fails on every retry
+	f.failNext(3)
+	f.events <- `{"change":"init","current":{"num":2,"name":"2","output":"DP-1"}}`
+
+	f.setWorkspaces(`[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"}]`)
+	f.events <- `{"change":"urgent","current":{"num":1,"name":"1","focused":true,"urgent":false,"output":"DP-1"}}`
+	// nothing was written for the failed fetch, so this is the next widget
+	if got, want := nextLine(t, lines), widgetLine("focused", "unoccupied", "unoccupied"); got != want {
+		t.Fatalf("widget after clearing\n%s\nwant\n%s", got, want)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures != 0 {
+		t.Errorf("%d fetches did not fail as planned", f.failures)
+	}
+}
+
+func TestUrgentFlashOnlyForShownOutputs(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		flash bool
+	}{
+		{name: "urgent on the output", reply: `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"},{"num":2,"name":"2","urgent":true,"output":"DP-1"}]`, flash: true},
+		{name: "urgent on another output", reply: `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"},{"num":2,"name":"2","urgent":true,"output":"HDMI-A-1"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeCompositor(t, tt.reply)
+			captureStdout(t)
+			cfg := testConfig(t, "-end-workspace", "3", "-urgent-flash", "1h")
+			be, err := detectBackend(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := newSink(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &watcher{cfg: cfg, be: be, out: out}
+			if err := w.refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.render(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if flash := w.flash != nil; flash != tt.flash {
+				t.Errorf("flashing %t, want %t", flash, tt.flash)
+			}
+			w.updateFlash(false)
+		})
+	}
+}
+
+func TestWaitForFileFailsAtOnce(t *testing.T) {
+	dir := t.TempDir()
+	unreadable := filepath.Join(dir, "unreadable.json")
+	if err := os.WriteFile(unreadable, []byte("[]"), 0o000); err != nil {
+		t.Fatal(err)
+	}
+	dangling := filepath.Join(dir, "dangling.json")
+	if err := os.Symlink(filepath.Join(dir, "missing.json"), dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		// root reads files regardless of their permissions
+		needsNonRoot bool
+		want         string
+		wantErr      error
+	}{
+		{name: "directory", path: dir, want: dir + " is a directory"},
+		{name: "permission denied", path: unreadable, needsNonRoot: true, want: "permission denied", wantErr: os.ErrPermission},
+		{name: "dangling symlink", path: dangling, want: "a symlink to a missing file", wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsNonRoot && os.Geteuid() == 0 {
+				t.Skip("running as root")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := waitForFile(ctx, tt.path, time.Millisecond)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("waitForFile(%s) = %v, want an error containing %q", tt.path, err, tt.want)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("waitForFile(%s) = %v, want %v", tt.path, err, tt.wantErr)
+			}
+			if tt.wantErr != context.DeadlineExceeded && errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("waitForFile(%s) polled until the timeout", tt.path)
+			}
+		})
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	btns := []ButtonState{
+		{Num: 11, DisplayNum: 1, Name: "11", State: "focused", Visible: true, Label: "1"},
+		{Num: 12, DisplayNum: 2, Name: "12", State: "unoccupied", Label: "2"},
+	}
+	const buttons = `[{"num":11,"display_num":1,"state":"focused","visible":true,"label":"1"},` +
+		`{"num":12,"display_num":2,"state":"unoccupied","visible":false,"label":"2"}]`
+	tests := []struct {
+		name   string
+		format func() (string, error)
+		want   string
+	}{
+		{"buttons", func() (string, error) { return formatJSON(btns) }, buttons},
+		{"no buttons", func() (string, error) { return formatJSON(nil) }, `[]`},
+		{
+			"meta",
+			func() (string, error) { return formatJSONMeta(btns, "left", "DP-1") },
+			`{"monitor":"left","output":"DP-1","buttons":` + buttons + `}`,
+		},
+		{
+			"meta of detected output",
+			func() (string, error) { return formatJSONMeta(nil, "auto", "DP-1") },
+			`{"monitor":"","output":"DP-1","buttons":[]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.format()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupMonitor(t *testing.T) {
+	infos := []MonitorInfo{
+		{Monitor: "left", Output: "HDMI-A-1"},
+		{Monitor: "right", Output: "DP-1"},
+		{Monitor: "0", Output: "DP-2"},
+	}
+	tests := []struct {
+		monitor string
+		want    string
+		ok      bool
+	}{
+		{"right", "DP-1", true},
+		{"1", "DP-1", true},
+		{"2", "DP-2", true},
+		// a name wins over the index
+		{"0", "DP-2", true},
+		{"3", "", false},
+		{"-1", "", false},
+		{"middle", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.monitor, func(t *testing.T) {
+			got, ok := lookupMonitor(infos, tt.monitor)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("lookupMonitor(%q) = %q, %t, want %q, %t", tt.monitor, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	monitorsFile := filepath.Join(t.TempDir(), "monitors.json")
+	if err := os.WriteFile(monitorsFile, []byte(`[{"monitor":"left","output":"HDMI-A-1"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+		err  error
+	}{
+		{name: "no flags", want: "DP-1"},
+		{name: "monitor", args: []string{"-monitor", "left", "-monitors-file", monitorsFile}, want: "HDMI-A-1"},
+		{name: "monitors file without monitor", args: []string{"-monitors-file", monitorsFile}, err: errNoMonitor},
+		{name: "monitors file with auto", args: []string{"-monitor", "auto", "-monitors-file", monitorsFile}, want: "DP-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newFakeCompositor(t, `[]`)
+			cfg := testConfig(t, tt.args...)
+			be, err := detectBackend(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := &watcher{cfg: cfg, be: be}
+			err = w.resolve(context.Background())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("resolve() error %v, want %v", err, tt.err)
+			}
+			if w.output != tt.want {
+				t.Errorf("output %q, want %q", w.output, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconnectAfterHealthySubscription(t *testing.T) {
+	orig := healthySubscription
+	healthySubscription = 20 * time.Millisecond
+	t.Cleanup(func() { healthySubscription = orig })
+
+	f := newFakeCompositor(t, `[{"num":1,"name":"1","focused":true,"visible":true,"output":"DP-1"}]`)
+	lines := watchFake(t, f, "-max-reconnect", "1")
+	nextLine(t, lines)
+	// without starting over after each long session, the second
+	// disconnect would exceed --max-reconnect
+	for i := range 3 {
+		time.Sleep(2 * healthySubscription)
+		f.ends <- struct{}{}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			f.mu.Lock()
+			subscribes := f.subscribes
+			f.mu.Unlock()
+			if subscribes == i+2 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("no resubscription after disconnect %d", i+1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestWatchOnlyFlags(t *testing.T) {
+	t.Setenv(monitorEnv, "")
+	for _, command := range []string{"render", "check", "goto"} {
+		for _, args := range [][]string{{"-max-reconnect", "3"}, {"-poll"}, {"-sink", "stdout"}, {"-debounce", "0"}, {"-on-events", "workspace"}} {
+			if _, _, err := parseFlags(append([]string{command, "-config", ""}, args...), flag.ContinueOnError); err == nil {
+				t.Errorf("%s accepted %s", command, strings.Join(args, " "))
+			}
+		}
+	}
+	cfg, opts, err := parseFlags([]string{"watch", "-config", "", "-max-reconnect", "3", "-poll"}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxReconnect != 3 || !opts.poll {
+		t.Errorf("watch parsed max-reconnect %d, poll %t", cfg.MaxReconnect, opts.poll)
+	}
+
+	// a config file shared with watch may set them
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("max-reconnect = 3\nend-workspace = 4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err = parseFlags([]string{"render", "-config", path}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxReconnect != 0 || cfg.EndWS != 4 {
+		t.Errorf("render took max-reconnect %d, end-workspace %d from the config file, want 0, 4", cfg.MaxReconnect, cfg.EndWS)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	notFound := func(name string) error { return &exec.Error{Name: name, Err: exec.ErrNotFound} }
+	_, _, parseErr := parseFlags([]string{"render", "-config", "", "--bogus"}, flag.ContinueOnError)
+	if parseErr == nil {
+		t.Fatal("parsing --bogus succeeded")
+	}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"usage", fmt.Errorf("%w: %w", errUsage, parseErr), exitUsage},
+		{"autodetection without sway", fmt.Errorf("%w: %w", errNoMonitor, notFound("swaymsg")), exitNoMonitor},
+		{"no compositor", fmt.Errorf("%w (looked for swaymsg)", errNoCompositor), exitNoCompositor},
+		{"compositor CLI gone", fmt.Errorf("get_workspaces: %w", notFound("/usr/bin/swaymsg")), exitNoCompositor},
+		{"eww missing", fmt.Errorf("eww update workspaces: %w", notFound("eww")), exitError},
+		{"monitors file", fmt.Errorf("%w: timeout", errMonitorsFile), exitMonitorsFile},
+		{"monitor not found", fmt.Errorf("%w: %q", errMonitorNotFound, "left"), exitMonitorNotFound},
+		{"other", errors.New("render failed"), exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconfigureLogsFixedSettings(t *testing.T) {
+	var logs bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	newFakeCompositor(t, `[]`)
+	captureStdout(t)
+	cfg := testConfig(t)
+	w := &watcher{cfg: cfg, be: &swayBackend{i3Backend{cmd: "swaymsg"}}, output: "DP-1", out: io.Discard}
+	reloaded := testConfig(t, "-workspaces-file", "/tmp/workspaces.json", "-end-workspace", "5")
+	w.reconfigure(context.Background(), reloaded)
+
+	want := `msg="setting cannot change without a restart, ignored" flag=workspaces-file`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("logs\n%s\nlack %s", logs.String(), want)
+	}
+	if w.cfg.WorkspacesFile != "" || w.cfg.EndWS != 5 {
+		t.Errorf("reconfigured to workspaces file %q and end workspace %d, want none and 5", w.cfg.WorkspacesFile, w.cfg.EndWS)
+	}
+}
@@ -0,0 +1,153 @@
+package program
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// riverOutput is one element of a river status line: the tag bitmasks of a
+// single output.
+type riverOutput struct {
+	Output   string `json:"output"`
+	Focused  uint32 `json:"focused"`
+	Occupied uint32 `json:"occupied"`
+	Urgent   uint32 `json:"urgent"`
+}
+
+// riverBackend reads river's tag state from a status helper and drives river
+// through riverctl.
+//
+// riverctl can only send commands, so the tag state comes from the command
+// given with --river-status-cmd, typically a river-status client wrapped to
+// print one JSON array of riverOutput objects per line whenever tags change.
+type riverBackend struct {
+	cmd       string
+	statusCmd string
+}
+
+// detectRiver returns a river backend when the session is river and
+// riverctl is installed.
+func detectRiver(_ context.Context, cfg config) (Backend, bool) {
+	if !strings.Contains(strings.ToLower(os.Getenv("XDG_CURRENT_DESKTOP")), "river") {
+		return nil, false
+	}
+	riverPath, err := lookPath("riverctl")
+	if err != nil {
+		return nil, false
+	}
+	return &riverBackend{cmd: riverPath, statusCmd: cfg.RiverStatusCmd}, true
+}
+
+func (b *riverBackend) Name() string { return "river" }
+
+// status starts the status helper, returning a scanner over its output and
+// a function waiting for it to exit.
+func (b *riverBackend) status(ctx context.Context) (*bufio.Scanner, func() error, error) {
+	if b.statusCmd == "" {
+		return nil, nil, errors.New("river needs --river-status-cmd to read tag state")
+	}
+	stdout, wait, err := commandStream(ctx, "sh", "-c", b.statusCmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("river status command: %w", err)
+	}
+	return bufio.NewScanner(stdout), wait, nil
+}
+
+// Workspaces reads the first line of the status helper.
+func (b *riverBackend) Workspaces(ctx context.Context) ([]Workspace, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scanner, wait, err := b.status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer wait()
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("river status command: %w", err)
+		}
+		return nil, errors.New("river status command produced no output")
+	}
+	var outputs []riverOutput
+	if err := json.Unmarshal(scanner.Bytes(), &outputs); err != nil {
+		return nil, fmt.Errorf("unmarshal river status JSON: %w", err)
+	}
+	return riverWorkspaces(outputs), nil
+}
+
+// Subscribe emits a workspace event for every line of the status helper.
+func (b *riverBackend) Subscribe(ctx context.Context) (<-chan Event, error) {
+	scanner, wait, err := b.status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	evCh := make(chan Event)
+	go func() {
+		defer close(evCh)
+		// cancelling ctx kills the helper, so this does not block
+		defer func() {
+			if err := wait(); err != nil && ctx.Err() == nil {
+				slog.Warn("river status command exited", "err", err)
+			}
+		}()
+		for scanner.Scan() {
+			select {
+			case evCh <- Event{Change: "tags", kind: "workspace"}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return evCh, nil
+}
+
+// riverWorkspaces adapts river's tag bitmasks to workspaces: tag bit i
+// becomes workspace i+1, present when it is focused or occupied.
+func riverWorkspaces(outputs []riverOutput) []Workspace {
+	var wss []Workspace
+	for _, o := range outputs {
+		for i := range 32 {
+			bit := uint32(1) << i
+			if (o.Focused|o.Occupied)&bit == 0 {
+				continue
+			}
+			wss = append(wss, Workspace{
+				Name:    strconv.Itoa(i + 1),
+				Num:     i + 1,
+				Focused: o.Focused&bit != 0,
+				Visible: o.Focused&bit != 0,
+				Urgent:  o.Urgent&bit != 0,
+				Output:  o.Output,
+			})
+		}
+	}
+	return wss
+}
+
+// Command maps switch and move onto tag bitmasks. river has no relative
+// tag cycling or scratchpad, so those actions are unsupported.
+func (b *riverBackend) Command(action, target string) string {
+	if action == "" {
+		return b.cmd
+	}
+	n, err := strconv.Atoi(target)
+	if err != nil || n < 1 || n > 32 {
+		return ""
+	}
+	mask := uint32(1) << (n - 1)
+	switch action {
+	case "switch":
+		return fmt.Sprintf("%s set-focused-tags %d", b.cmd, mask)
+	case "move":
+		return fmt.Sprintf("%s set-view-tags %d", b.cmd, mask)
+	}
+	return ""
+}
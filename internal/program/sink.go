@@ -0,0 +1,191 @@
+package program
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sinks lists the values accepted by --sink.
+var sinks = []string{"stdout", "eww-update", "fifo"}
+
+// newSink returns the writer rendered widgets are written to, one complete
+// widget per Write. A fifo sink stops writing once ctx is cancelled.
+func newSink(ctx context.Context, cfg config) (io.Writer, error) {
+	switch cfg.Sink {
+	case "eww-update":
+		return &ewwUpdateSink{cmd: "eww", variable: cfg.EwwVar, timeout: cfg.FetchTimeout}, nil
+	case "fifo":
+		return newFifoSink(ctx, cfg.Fifo)
+	}
+	return os.Stdout, nil
+}
+
+// ewwUpdateSink sets an EWW variable to each widget written to it by running
+// `eww update`, for bars that use a defvar instead of a deflisten.
+type ewwUpdateSink struct {
+	cmd      string
+	variable string
+	timeout  time.Duration
+}
+
+func (s *ewwUpdateSink) Write(p []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	value := strings.TrimSuffix(string(p), "\n")
+	if _, err := commandOutput(ctx, s.cmd, "update", s.variable+"="+value); err != nil {
+		return 0, fmt.Errorf("%s update %s: %w", s.cmd, s.variable, err)
+	}
+	return len(p), nil
+}
+
+// fifoSink writes widgets to named pipes, for consumers such as
+// `cat PATH` in a deflisten that start and stop independently of this
+// process. A pipe hands each line to a single reader, so every consumer gets
+// a pipe of its own. Writes never block the watcher: a goroutine per pipe
+// waits for its reader, writes the latest widget to it and reopens the pipe
+// for the next reader once it goes away.
+type fifoSink struct {
+	pipes []*fifoPipe
+}
+
+// fifoPipe is one named pipe of a fifoSink.
+type fifoPipe struct {
+	path string
+	// widgets holds the widget not yet written, replaced by newer ones.
+	widgets chan []byte
+}
+
+// newFifoSink creates the named pipes at paths unless they exist and starts
+// writing to them until ctx is cancelled.
+func newFifoSink(ctx context.Context, paths []string) (*fifoSink, error) {
+	s := &fifoSink{}
+	for _, path := range paths {
+		if err := makeFifo(path); err != nil {
+			return nil, err
+		}
+		s.pipes = append(s.pipes, &fifoPipe{path: path, widgets: make(chan []byte, 1)})
+	}
+	for _, p := range s.pipes {
+		go p.run(ctx)
+	}
+	return s, nil
+}
+
+// makeFifo creates the named pipe at path unless it exists.
+func makeFifo(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := syscall.Mkfifo(path, 0o600); err != nil {
+			return fmt.Errorf("fifo %s: %w", path, err)
+		}
+	case err != nil:
+		return fmt.Errorf("fifo %s: %w", path, err)
+	case info.Mode().Type() != os.ModeNamedPipe:
+		return fmt.Errorf("fifo %s exists and is not a named pipe", path)
+	}
+	return nil
+}
+
+func (s *fifoSink) Write(p []byte) (int, error) {
+	// the pipes only read the widget
+	widget := bytes.Clone(p)
+	for _, pipe := range s.pipes {
+		pipe.offer(widget)
+	}
+	return len(p), nil
+}
+
+// offer queues widget for the pipe, dropping the widget nobody has read yet
+// in favour of it.
+func (p *fifoPipe) offer(widget []byte) {
+	for {
+		select {
+		case p.widgets <- widget:
+			return
+		default:
+		}
+		select {
+		case <-p.widgets:
+		default:
+		}
+	}
+}
+
+// run writes every widget to the pipe, and the last one again to each new
+// reader, so a consumer starting late shows the current state at once. It
+// returns once ctx is cancelled.
+func (p *fifoPipe) run(ctx context.Context) {
+	var last []byte
+	for {
+		f, err := openFifo(ctx, p.path)
+		if ctx.Err() != nil {
+			if err == nil {
+				f.Close()
+			}
+			return
+		}
+		if err != nil {
+			slog.Error("opening fifo failed", "path", p.path, "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		slog.Debug("fifo reader connected", "path", p.path)
+		if !p.writeAll(ctx, f, &last) {
+			f.Close()
+			return
+		}
+		f.Close()
+	}
+}
+
+// writeAll writes *last, if any, and every further widget to f until the
+// reader goes away, keeping the widget that failed in *last for the next
+// one. It reports false once ctx is cancelled.
+func (p *fifoPipe) writeAll(ctx context.Context, f *os.File, last *[]byte) bool {
+	for {
+		if *last == nil {
+			select {
+			case <-ctx.Done():
+				return false
+			case *last = <-p.widgets:
+			}
+		}
+		if _, err := f.Write(*last); err != nil {
+			// EPIPE once the reader is gone
+			slog.Debug("fifo reader disconnected", "path", p.path, "err", err)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case *last = <-p.widgets:
+		}
+	}
+}
+
+// openFifo opens the named pipe at path for writing, which blocks until a
+// reader opens it or ctx is cancelled.
+func openFifo(ctx context.Context, path string) (*os.File, error) {
+	// on cancellation, open the pipe for reading ourselves so the blocked
+	// open returns
+	stop := context.AfterFunc(ctx, func() {
+		if r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			r.Close()
+		}
+	})
+	defer stop()
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
@@ -0,0 +1,58 @@
+package program
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFifoSinkFansOut(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := newFifoSink(ctx, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("(box)\n")); err != nil {
+		t.Fatal(err)
+	}
+	// every reader gets every widget, not a share of them
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil || line != "(box)\n" {
+			t.Errorf("%s read %q, %v, want (box)", path, line, err)
+		}
+	}
+}
+
+func TestFifoPipeStopsWithoutReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := makeFifo(path); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &fifoPipe{path: path, widgets: make(chan []byte, 1)}
+	done := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(done)
+	}()
+	// let run block opening the pipe
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run kept waiting for a reader after cancellation")
+	}
+}
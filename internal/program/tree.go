@@ -0,0 +1,165 @@
+package program
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/qikiqi/go-eww-workspaces/workspaces"
+)
+
+// treeNode is the subset of a `get_tree` node we care about.
+type treeNode struct {
+	Type           string           `json:"type"`
+	Name           string           `json:"name"`
+	AppID          string           `json:"app_id"`
+	WindowProps    windowProperties `json:"window_properties"`
+	Focused        bool             `json:"focused"`
+	FullscreenMode int              `json:"fullscreen_mode"`
+	Nodes          []treeNode       `json:"nodes"`
+	FloatingNodes  []treeNode       `json:"floating_nodes"`
+}
+
+// windowProperties holds the X11 properties i3 reports for a window.
+type windowProperties struct {
+	Class string `json:"class"`
+}
+
+// ipcQueryFunc fetches the reply to an i3/sway IPC message named as for
+// `i3-msg -t`.
+type ipcQueryFunc func(ctx context.Context, msgType string) ([]byte, error)
+
+// fetchTree retrieves the layout tree through query.
+func fetchTree(ctx context.Context, query ipcQueryFunc) (treeNode, error) {
+	out, err := query(ctx, "get_tree")
+	if err != nil {
+		return treeNode{}, err
+	}
+	var root treeNode
+	if err := json.Unmarshal(out, &root); err != nil {
+		return treeNode{}, fmt.Errorf("unmarshal tree JSON: %w", err)
+	}
+	return root, nil
+}
+
+// find returns the first node below n, depth first, for which match is true.
+func (n treeNode) find(match func(treeNode) bool) (treeNode, bool) {
+	for _, children := range [][]treeNode{n.Nodes, n.FloatingNodes} {
+		for _, c := range children {
+			if match(c) {
+				return c, true
+			}
+			if found, ok := c.find(match); ok {
+				return found, true
+			}
+		}
+	}
+	return treeNode{}, false
+}
+
+// windowCount returns the number of windows (leaf containers) below n.
+func (n treeNode) windowCount() int {
+	count := 0
+	for _, children := range [][]treeNode{n.Nodes, n.FloatingNodes} {
+		for _, c := range children {
+			if len(c.Nodes) == 0 && len(c.FloatingNodes) == 0 {
+				if c.Type == "con" || c.Type == "floating_con" {
+					count++
+				}
+				continue
+			}
+			count += c.windowCount()
+		}
+	}
+	return count
+}
+
+// windowTitles returns the titles of the windows below n, falling back to
+// the app ID for untitled windows.
+func (n treeNode) windowTitles() []string {
+	var titles []string
+	for _, children := range [][]treeNode{n.Nodes, n.FloatingNodes} {
+		for _, c := range children {
+			if len(c.Nodes) == 0 && len(c.FloatingNodes) == 0 {
+				if c.Type == "con" || c.Type == "floating_con" {
+					titles = append(titles, cmp.Or(c.Name, c.AppID))
+				}
+				continue
+			}
+			titles = append(titles, c.windowTitles()...)
+		}
+	}
+	return titles
+}
+
+// workspaceWindows maps the name of every workspace below n to the titles of
+// its windows.
+func workspaceWindows(n treeNode) map[string][]string {
+	windows := make(map[string][]string)
+	var walk func(treeNode)
+	walk = func(n treeNode) {
+		for _, c := range n.Nodes {
+			if c.Type == "workspace" {
+				windows[c.Name] = c.windowTitles()
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return windows
+}
+
+// fullscreenWorkspaces returns the names of the workspaces below n holding a
+// fullscreen window.
+func fullscreenWorkspaces(n treeNode) map[string]bool {
+	fullscreen := make(map[string]bool)
+	var walk func(treeNode)
+	walk = func(n treeNode) {
+		for _, c := range n.Nodes {
+			if c.Type == "workspace" {
+				_, fullscreen[c.Name] = c.find(func(n treeNode) bool { return n.FullscreenMode != 0 })
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return fullscreen
+}
+
+// focusedApp returns the name of the workspace below n holding the focused
+// window and that window's app ID, or its class under X11.
+func focusedApp(n treeNode) (workspace, app string, ok bool) {
+	var walk func(treeNode) bool
+	walk = func(n treeNode) bool {
+		for _, c := range n.Nodes {
+			if c.Type != "workspace" {
+				if walk(c) {
+					return true
+				}
+				continue
+			}
+			win, found := c.find(func(n treeNode) bool { return n.Focused })
+			if found {
+				workspace, app, ok = c.Name, cmp.Or(win.AppID, win.WindowProps.Class), true
+				return true
+			}
+		}
+		return false
+	}
+	walk(n)
+	return workspace, app, ok
+}
+
+// scratchpadCount returns the number of windows hidden in the scratchpad.
+func scratchpadCount(root treeNode) int {
+	ws, ok := root.find(func(n treeNode) bool {
+		return n.Type == "workspace" && n.Name == workspaces.ScratchpadName
+	})
+	if !ok {
+		return 0
+	}
+	return ws.windowCount()
+}
@@ -0,0 +1,90 @@
+package program
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile reports changes to the file at path on the returned channel until
+// ctx is done. The parent directory is watched rather than the file itself so
+// editors that save by renaming a new file over the old one are still seen.
+// If fsnotify cannot watch the directory, it falls back to polling the file's
+// size and modification time every pollInterval.
+func watchFile(ctx context.Context, path string, pollInterval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		// coalesce: a pending notification already covers this change
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	path = filepath.Clean(path)
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		slog.Warn("cannot watch file, polling instead", "path", path, "err", err, "interval", pollInterval)
+		go pollFile(ctx, path, pollInterval, notify)
+		return changed
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != path {
+					continue
+				}
+				if ev.Has(fsnotify.Write) || ev.Has(fsnotify.Create) || ev.Has(fsnotify.Rename) || ev.Has(fsnotify.Remove) {
+					notify()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("watching file failed", "path", path, "err", err)
+			}
+		}
+	}()
+	return changed
+}
+
+// pollFile calls notify whenever the size or modification time of the file
+// at path changes, or it appears or disappears.
+func pollFile(ctx context.Context, path string, interval time.Duration, notify func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last os.FileInfo
+	last, _ = os.Stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, _ := os.Stat(path)
+			switch {
+			case fi == nil && last == nil:
+			case fi == nil || last == nil, fi.Size() != last.Size(), !fi.ModTime().Equal(last.ModTime()):
+				notify()
+			}
+			last = fi
+		}
+	}
+}
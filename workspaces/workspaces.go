@@ -0,0 +1,374 @@
+// Package workspaces computes the state of the workspace buttons shown by
+// go-eww-workspaces from a compositor's workspace list, independently of how
+// they are rendered. Workspaces follow the shape of the i3/sway
+// `get_workspaces` reply.
+package workspaces
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ScratchpadName is the name i3 and sway give the scratchpad workspace.
+const ScratchpadName = "__i3_scratch"
+
+// Workspace is a workspace as reported by the compositor.
+type Workspace struct {
+	Name    string `json:"name"`
+	Num     int    `json:"num"`
+	Focused bool   `json:"focused"`
+	// Visible marks the workspace shown on its output, focused or not.
+	Visible bool   `json:"visible"`
+	Urgent  bool   `json:"urgent"`
+	Output  string `json:"output"`
+}
+
+// ButtonState is the computed state of one workspace button. It is passed to
+// the button template of the EWW format and marshalled as-is in the JSON
+// format.
+type ButtonState struct {
+	Num int `json:"num"`
+	// DisplayNum is Num less Options.DisplayOffset, which numbered buttons
+	// are labelled with.
+	DisplayNum int    `json:"display_num"`
+	Name       string `json:"-"`
+	State      string `json:"state"`
+	Visible    bool   `json:"visible"`
+	Label      string `json:"label"`
+	// Tooltip lists the windows on the workspace with Options.Tooltips,
+	// empty when it has none.
+	Tooltip string `json:"tooltip,omitempty"`
+	// WindowCount is the number of windows on the workspace, only known
+	// when Options.Windows is set.
+	WindowCount int `json:"window_count,omitempty"`
+
+	// Command is the compositor command prefix, and OnClick,
+	// OnMiddleClick and OnRightClick are the full commands for the
+	// configured click actions, empty for "none". Compute leaves them for
+	// the renderer to fill in.
+	Command       string `json:"-"`
+	OnClick       string `json:"-"`
+	OnMiddleClick string `json:"-"`
+	OnRightClick  string `json:"-"`
+}
+
+// Options select which buttons Compute returns and how they are labelled.
+type Options struct {
+	// StartWS and EndWS bound the numbered buttons.
+	StartWS int
+	EndWS   int
+	// UseNames shows a button per existing workspace, labelled by name.
+	UseNames bool
+	// DisplayOffset is subtracted from the workspace numbers shown, e.g. 10
+	// to label workspaces 11 to 15 as 1 to 5.
+	DisplayOffset int
+	// DynamicRange shows only existing, persistent and assigned workspace
+	// numbers instead of the StartWS to EndWS range.
+	DynamicRange bool
+	Reverse      bool
+	HideEmpty    bool
+	// Persistent lists workspace numbers or names that are always shown.
+	Persistent []string
+	// UrgentPriority is the state of a focused urgent workspace, see State.
+	UrgentPriority string
+	Tooltips       bool
+	ShowCounts     bool
+
+	// Assignments maps workspace names to their configured output, nil if
+	// unknown.
+	Assignments map[string]string
+	// Windows maps workspace names to the titles of their windows, nil if
+	// unknown.
+	Windows map[string][]string
+	// Fullscreen holds the names of the workspaces with a fullscreen
+	// window, nil if unknown.
+	Fullscreen map[string]bool
+	// FocusedApps maps workspace names to the app ID of their focused
+	// window, and AppIcons app IDs to an icon appended to the label of the
+	// focused workspace.
+	FocusedApps map[string]string
+	AppIcons    map[string]string
+}
+
+// persistentNums returns the numbers of the persistent workspaces, taken
+// from the leading digits of names like "1:web".
+func (o Options) persistentNums() []int {
+	var nums []int
+	for _, p := range o.Persistent {
+		if num, ok := NameNum(p); ok {
+			nums = append(nums, num)
+		}
+	}
+	return nums
+}
+
+// NameNum returns the workspace number i3/sway derive from a workspace name
+// such as "3" or "3:web".
+func NameNum(name string) (int, bool) {
+	end := 0
+	for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[:end])
+	return n, err == nil
+}
+
+// Compute returns the button states for the workspaces on output. The
+// result does not depend on the order of wss.
+func Compute(wss []Workspace, output string, opts Options) []ButtonState {
+	// compositors promise no order, so work on the workspaces sorted by
+	// number and name
+	wss = slices.Clone(wss)
+	slices.SortFunc(wss, func(a, b Workspace) int {
+		return cmp.Or(cmp.Compare(a.Num, b.Num), strings.Compare(a.Name, b.Name))
+	})
+	var btns []ButtonState
+	switch {
+	case opts.UseNames:
+		btns = namedButtons(wss, output, opts)
+	case opts.DynamicRange:
+		btns = dynamicButtons(wss, output, opts)
+	default:
+		btns = numberedButtons(wss, output, opts)
+	}
+	for i := range btns {
+		btns[i].DisplayNum = btns[i].Num
+		// unnumbered workspaces keep -1
+		if btns[i].Num >= 0 {
+			btns[i].DisplayNum -= opts.DisplayOffset
+		}
+		if !opts.UseNames {
+			btns[i].Label = strconv.Itoa(btns[i].DisplayNum)
+		}
+	}
+	if opts.Windows != nil {
+		for i := range btns {
+			titles := opts.Windows[btns[i].Name]
+			btns[i].WindowCount = len(titles)
+			if opts.Tooltips {
+				// titles are joined on one line since EWW reads one widget per line
+				btns[i].Tooltip = strings.Join(titles, ", ")
+			}
+			if opts.ShowCounts && len(titles) > 0 {
+				btns[i].Label = fmt.Sprintf("%s·%d", btns[i].Label, len(titles))
+			}
+		}
+	}
+	for i := range btns {
+		if !strings.HasPrefix(btns[i].State, "focused") {
+			continue
+		}
+		// a second class, so CSS can match .focused.fullscreen
+		if opts.Fullscreen[btns[i].Name] {
+			btns[i].State += " fullscreen"
+		}
+		// without a mapping the label stays the plain number or name
+		if icon, ok := opts.AppIcons[opts.FocusedApps[btns[i].Name]]; ok {
+			btns[i].Label += " " + icon
+		}
+	}
+	if opts.Reverse {
+		slices.Reverse(btns)
+	}
+	return btns
+}
+
+// IsScratchpad reports whether ws is the i3/sway scratchpad or a Hyprland
+// special workspace, neither of which gets a regular button.
+func IsScratchpad(ws Workspace) bool {
+	return ws.Name == ScratchpadName || strings.HasPrefix(ws.Name, "special:")
+}
+
+// State returns the CSS state class for an existing workspace. The
+// states take precedence as urgent > focused > visible > occupied, and
+// workspaces that do not exist are unoccupied. urgentPriority decides a
+// workspace that is both focused and urgent: "urgent" classes it urgent,
+// "focused" classes it focused and "combined" classes it focused-urgent.
+func State(ws Workspace, urgentPriority string) string {
+	if ws.Focused && ws.Urgent {
+		switch urgentPriority {
+		case "focused":
+			return "focused"
+		case "combined":
+			return "focused-urgent"
+		}
+	}
+	switch {
+	case ws.Urgent:
+		return "urgent"
+	case ws.Focused:
+		return "focused"
+	case ws.Visible:
+		return "visible"
+	default:
+		return "occupied"
+	}
+}
+
+// States lists the states State returns, and "unoccupied", in ascending
+// precedence.
+var States = []string{"unoccupied", "occupied", "visible", "focused", "urgent", "focused-urgent"}
+
+// stateRank orders states by precedence, higher ranks winning.
+func stateRank(state string) int {
+	return slices.Index(States, state)
+}
+
+// numberedButtons returns one button per workspace number in the configured
+// range, marking the ones that exist on output with their state. With
+// HideEmpty, unoccupied buttons are hidden unless listed in Persistent.
+// Empty workspaces assigned to another output are hidden, and those
+// assigned to output are shown even with HideEmpty.
+func numberedButtons(wss []Workspace, output string, opts Options) []ButtonState {
+	count := opts.EndWS - opts.StartWS + 1
+	persistent := opts.persistentNums()
+	states := make([]string, count)
+	visible := make([]bool, count)
+	names := make([]string, count)
+	for i := range count {
+		num := opts.StartWS + i
+		states[i] = "unoccupied"
+		visible[i] = !opts.HideEmpty || slices.Contains(persistent, num)
+		names[i] = strconv.Itoa(num)
+	}
+	for name, out := range opts.Assignments {
+		num, ok := NameNum(name)
+		if !ok || num < opts.StartWS || num > opts.EndWS {
+			continue
+		}
+		visible[num-opts.StartWS] = out == output
+	}
+
+	for _, ws := range wss {
+		if ws.Output != output {
+			continue
+		}
+		// workspaces outside the configured range have no button; this
+		// also covers the scratchpad, which i3/sway number -1
+		if ws.Num < opts.StartWS || ws.Num > opts.EndWS {
+			continue
+		}
+		idx := ws.Num - opts.StartWS
+		// several workspaces on one output can share a number, e.g.
+		// "1:web" and "1:mail"; the button shows the most notable one
+		state := State(ws, opts.UrgentPriority)
+		if stateRank(state) < stateRank(states[idx]) {
+			continue
+		}
+		states[idx] = state
+		visible[idx] = true
+		names[idx] = ws.Name
+	}
+
+	btns := make([]ButtonState, 0, count)
+	for i := range count {
+		num := opts.StartWS + i
+		btns = append(btns, ButtonState{
+			Num:     num,
+			Name:    names[i],
+			State:   states[i],
+			Visible: visible[i],
+			Label:   strconv.Itoa(num),
+		})
+	}
+	return btns
+}
+
+// dynamicButtons returns one button per workspace number that exists on
+// output, is listed in Persistent or is assigned to output, in ascending
+// order and regardless of the configured range.
+func dynamicButtons(wss []Workspace, output string, opts Options) []ButtonState {
+	existing := make(map[int]Workspace)
+	nums := opts.persistentNums()
+	for _, ws := range wss {
+		// i3/sway number unnumbered workspaces and the scratchpad -1
+		if ws.Output != output || ws.Num < 0 || IsScratchpad(ws) {
+			continue
+		}
+		if prev, ok := existing[ws.Num]; ok && stateRank(State(prev, opts.UrgentPriority)) > stateRank(State(ws, opts.UrgentPriority)) {
+			continue
+		}
+		existing[ws.Num] = ws
+		nums = append(nums, ws.Num)
+	}
+	for name, out := range opts.Assignments {
+		if num, ok := NameNum(name); ok && out == output {
+			nums = append(nums, num)
+		}
+	}
+	slices.Sort(nums)
+	nums = slices.Compact(nums)
+
+	btns := make([]ButtonState, 0, len(nums))
+	for _, num := range nums {
+		btn := ButtonState{
+			Num:     num,
+			Name:    strconv.Itoa(num),
+			State:   "unoccupied",
+			Visible: true,
+			Label:   strconv.Itoa(num),
+		}
+		if ws, ok := existing[num]; ok {
+			btn.Name = ws.Name
+			btn.State = State(ws, opts.UrgentPriority)
+		}
+		btns = append(btns, btn)
+	}
+	return btns
+}
+
+// namedButtons returns one button per workspace that exists on output, in
+// number and name order, followed by the empty workspaces
+// assigned to output and the persistent ones that do not exist, in name
+// order.
+func namedButtons(wss []Workspace, output string, opts Options) []ButtonState {
+	var btns []ButtonState
+	exists := make(map[string]bool)
+	for _, ws := range wss {
+		exists[ws.Name] = true
+		if ws.Output != output || IsScratchpad(ws) {
+			continue
+		}
+		btns = append(btns, ButtonState{
+			Num:     ws.Num,
+			Name:    ws.Name,
+			State:   State(ws, opts.UrgentPriority),
+			Visible: true,
+			Label:   ws.Name,
+		})
+	}
+
+	var empty []string
+	for name, out := range opts.Assignments {
+		if out == output && !exists[name] {
+			empty = append(empty, name)
+		}
+	}
+	for _, name := range opts.Persistent {
+		if !exists[name] {
+			empty = append(empty, name)
+		}
+	}
+	slices.Sort(empty)
+	empty = slices.Compact(empty)
+	for _, name := range empty {
+		num, ok := NameNum(name)
+		if !ok {
+			num = -1
+		}
+		btns = append(btns, ButtonState{
+			Num:     num,
+			Name:    name,
+			State:   "unoccupied",
+			Visible: true,
+			Label:   name,
+		})
+	}
+	return btns
+}
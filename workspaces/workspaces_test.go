@@ -0,0 +1,126 @@
+package workspaces
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	focusedUrgent := Workspace{Focused: true, Visible: true, Urgent: true}
+	tests := []struct {
+		name           string
+		ws             Workspace
+		urgentPriority string
+		want           string
+	}{
+		{"focused urgent, urgent wins", focusedUrgent, "urgent", "urgent"},
+		{"focused urgent, focused wins", focusedUrgent, "focused", "focused"},
+		{"focused urgent, combined", focusedUrgent, "combined", "focused-urgent"},
+		{"urgent", Workspace{Urgent: true}, "focused", "urgent"},
+		{"focused", Workspace{Focused: true, Visible: true}, "combined", "focused"},
+		{"visible", Workspace{Visible: true}, "urgent", "visible"},
+		{"occupied", Workspace{}, "urgent", "occupied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := State(tt.ws, tt.urgentPriority); got != tt.want {
+				t.Errorf("State(%+v, %q) = %q, want %q", tt.ws, tt.urgentPriority, got, tt.want)
+			}
+		})
+	}
+}
+
+// nums returns the workspace numbers of btns in order.
+func nums(btns []ButtonState) []int {
+	var ns []int
+	for _, btn := range btns {
+		ns = append(ns, btn.Num)
+	}
+	return ns
+}
+
+func TestComputeReverse(t *testing.T) {
+	wss := []Workspace{
+		{Num: 2, Name: "2", Output: "DP-1"},
+		{Num: 5, Name: "5", Focused: true, Visible: true, Output: "DP-1"},
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want []int
+	}{
+		{"range", Options{StartWS: 1, EndWS: 4}, []int{1, 2, 3, 4}},
+		{"range reversed", Options{StartWS: 1, EndWS: 4, Reverse: true}, []int{4, 3, 2, 1}},
+		{"dynamic", Options{DynamicRange: true}, []int{2, 5}},
+		{"dynamic reversed", Options{DynamicRange: true, Reverse: true}, []int{5, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nums(Compute(wss, "DP-1", tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("buttons %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeSameNumberOnOtherOutput(t *testing.T) {
+	wss := []Workspace{
+		{Num: 1, Name: "1:left", Output: "DP-1"},
+		{Num: 1, Name: "1:right", Focused: true, Visible: true, Urgent: true, Output: "HDMI-A-1"},
+	}
+	tests := []struct {
+		output, name, state string
+	}{
+		{"DP-1", "1:left", "occupied"},
+		{"HDMI-A-1", "1:right", "urgent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			btns := Compute(wss, tt.output, Options{StartWS: 1, EndWS: 2})
+			if btns[0].Name != tt.name || btns[0].State != tt.state {
+				t.Errorf("button 1 is %q %s, want %q %s", btns[0].Name, btns[0].State, tt.name, tt.state)
+			}
+			if btns[1].State != "unoccupied" {
+				t.Errorf("button 2 is %s, want unoccupied", btns[1].State)
+			}
+		})
+	}
+}
+
+// permutations returns every ordering of wss.
+func permutations(wss []Workspace) [][]Workspace {
+	if len(wss) <= 1 {
+		return [][]Workspace{slices.Clone(wss)}
+	}
+	var perms [][]Workspace
+	for i := range wss {
+		rest := slices.Delete(slices.Clone(wss), i, i+1)
+		for _, perm := range permutations(rest) {
+			perms = append(perms, append([]Workspace{wss[i]}, perm...))
+		}
+	}
+	return perms
+}
+
+func TestComputeIgnoresOrder(t *testing.T) {
+	wss := []Workspace{
+		{Num: 1, Name: "1:web", Output: "DP-1"},
+		{Num: 1, Name: "1:mail", Urgent: true, Output: "DP-1"},
+		{Num: 3, Name: "3", Focused: true, Visible: true, Output: "DP-1"},
+		{Num: -1, Name: "notes", Output: "DP-1"},
+		{Num: 3, Name: "3:other", Output: "HDMI-A-1"},
+	}
+	for _, opts := range []Options{
+		{StartWS: 1, EndWS: 4},
+		{DynamicRange: true},
+		{UseNames: true},
+	} {
+		want := Compute(wss, "DP-1", opts)
+		for _, perm := range permutations(wss) {
+			if got := Compute(perm, "DP-1", opts); !reflect.DeepEqual(got, want) {
+				t.Fatalf("Compute(%v, %+v) = %+v, want %+v", perm, opts, got, want)
+			}
+		}
+	}
+}